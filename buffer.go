@@ -49,6 +49,31 @@ func (b *logBuffer) Copy() *logBuffer {
 	}
 }
 
+// Take returns the current content as a new buffer and clears this one.
+func (b *logBuffer) Take() *logBuffer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := &logBuffer{
+		buffers: b.buffers,
+		size:    b.size,
+	}
+	b.size = 0
+	b.buffers = make([][]byte, 0)
+	return t
+}
+
+// Peek returns the first element without removing it from the buffer.
+func (b *logBuffer) Peek() ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if len(b.buffers) == 0 {
+		return nil, fmt.Errorf("no element left in buffer")
+	}
+	return b.buffers[0], nil
+}
+
 func (b *logBuffer) Pull() ([]byte, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -63,5 +88,8 @@ func (b *logBuffer) Pull() ([]byte, error) {
 }
 
 func (b *logBuffer) Size() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	return b.size
 }
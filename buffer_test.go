@@ -227,3 +227,36 @@ func Test_logBuffer_Size(t *testing.T) {
 	//    })
 	//}
 }
+
+func Test_logBuffer_Take(t *testing.T) {
+	b := NewLogBuffer()
+	b.Add([]byte(`{ "x":"Hello" }`))
+	b.Add([]byte(`{ "x":"World" }`))
+
+	c := b.Take()
+	if b.Size() != 0 || len(b.buffers) != 0 {
+		t.Errorf("buffer not empty after Take, size: %d", b.Size())
+	}
+	if c.Size() != 30 || len(c.buffers) != 2 {
+		t.Errorf("Take() size = %d, len = %d, want 30, 2", c.Size(), len(c.buffers))
+	}
+}
+
+func Test_logBuffer_Peek(t *testing.T) {
+	b := NewLogBuffer()
+	if _, err := b.Peek(); err == nil {
+		t.Errorf("Peek() on empty buffer, want error")
+	}
+
+	b.Add([]byte(`{ "x":"Hello" }`))
+	d, err := b.Peek()
+	if err != nil {
+		t.Fatalf("Peek() error = %v", err)
+	}
+	if string(d) != `{ "x":"Hello" }` {
+		t.Errorf("Peek() = %s", d)
+	}
+	if b.Size() != 15 {
+		t.Errorf("Peek() changed size, got: %d", b.Size())
+	}
+}
@@ -14,12 +14,15 @@ func main() {
 		Out:        os.Stdout,
 	}
 
-	t, err := zgelf.NewUdpTransport("192.168.0.11", 12345)
+	t, err := zgelf.NewUdpTransport("192.168.0.11:12345")
 	if err != nil {
 		log.Fatal().Err(err).Msg("can not initialize udpTransport")
 	}
 
-	gWriter := zgelf.New("W-RV", "", t)
+	gWriter, err := zgelf.New(t, zgelf.WithHost("W-RV"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("can not initialize gelf writer")
+	}
 	defer gWriter.Close()
 
 	multi := zerolog.MultiLevelWriter(consoleWriter, gWriter)
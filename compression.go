@@ -0,0 +1,82 @@
+package zgelf
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+type Compression string
+
+const (
+	CompressionNone = Compression("none")
+	CompressionGzip = Compression("gzip")
+	CompressionZlib = Compression("zlib")
+)
+
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// compressor compresses single messages, the writer and the
+// output buffer are reused for every message.
+type compressor struct {
+	compression Compression
+	buf         bytes.Buffer
+	w           resetWriter
+}
+
+func newCompressor(compression Compression, level int) (*compressor, error) {
+	if err := validateCompression(compression, level); err != nil {
+		return nil, err
+	}
+
+	c := compressor{compression: compression}
+	var err error
+	switch compression {
+	case CompressionGzip:
+		c.w, err = gzip.NewWriterLevel(&c.buf, level)
+	case CompressionZlib:
+		c.w, err = zlib.NewWriterLevel(&c.buf, level)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// compress returns the compressed data, the result is only valid
+// until the next call.
+func (c *compressor) compress(d []byte) ([]byte, error) {
+	if c.w == nil {
+		return d, nil
+	}
+
+	c.buf.Reset()
+	c.w.Reset(&c.buf)
+	if _, err := c.w.Write(d); err != nil {
+		return nil, err
+	}
+	if err := c.w.Close(); err != nil {
+		return nil, err
+	}
+	return c.buf.Bytes(), nil
+}
+
+func validateCompression(compression Compression, level int) error {
+	switch compression {
+	case CompressionNone, "":
+		return nil
+	case CompressionGzip, CompressionZlib:
+		if level < flate.HuffmanOnly || level > flate.BestCompression {
+			return fmt.Errorf("invalid compression level: %d", level)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown compression: %s", compression)
+	}
+}
@@ -0,0 +1,343 @@
+package zgelf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of the environment variables read by FromEnv.
+const EnvPrefix = "ZGELF_"
+
+// Config describes a GelfWriter, it can be read from a JSON or YAML file
+// or from environment variables.
+type Config struct {
+	// Transport is an url as accepted by ParseTransportURL.
+	Transport        string                 `json:"transport" yaml:"transport"`
+	Host             string                 `json:"host" yaml:"host"`
+	SpoolDir         string                 `json:"spool_dir" yaml:"spool_dir"`
+	StaticFields     map[string]interface{} `json:"static_fields" yaml:"static_fields"`
+	FieldMapping     map[string]string      `json:"field_mapping" yaml:"field_mapping"`
+	MinLevel         string                 `json:"min_level" yaml:"min_level"`
+	LevelMapping     map[string]int         `json:"level_mapping" yaml:"level_mapping"`
+	IdPolicy         string                 `json:"id_policy" yaml:"id_policy"`
+	KeyNormalization string                 `json:"key_normalization" yaml:"key_normalization"`
+	DenyFields       []string               `json:"deny_fields" yaml:"deny_fields"`
+	AllowFields      []string               `json:"allow_fields" yaml:"allow_fields"`
+	Redact           []string               `json:"redact" yaml:"redact"`
+	ShortLength      int                    `json:"short_message_length" yaml:"short_message_length"`
+	StackInFull      bool                   `json:"stack_in_full_message" yaml:"stack_in_full_message"`
+	MaxFieldLength   int                    `json:"max_field_length" yaml:"max_field_length"`
+	MaxMessageSize   int                    `json:"max_message_size" yaml:"max_message_size"`
+	QueueSize        int                    `json:"queue_size" yaml:"queue_size"`
+	Overflow         string                 `json:"overflow" yaml:"overflow"`
+	Workers          int                    `json:"workers" yaml:"workers"`
+	FlushInterval    string                 `json:"flush_interval" yaml:"flush_interval"`
+}
+
+// FromEnv creates a GelfWriter configured by environment variables, they
+// are named like the keys of the config file in upper case with the prefix
+// ZGELF_, e.g. ZGELF_TRANSPORT, ZGELF_SPOOL_DIR or ZGELF_MIN_LEVEL. If
+// ZGELF_CONFIG is set, the file is read first and the variables set take
+// precedence. Static fields and the mappings are lists of `key=value` pairs,
+// the field lists and the redaction rules (email, credit_card, bearer_token)
+// lists of values, separated by commas. The options are applied after the
+// configuration.
+func FromEnv(opts ...Option) (*GelfWriter, error) {
+	c, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return c.NewWriter(opts...)
+}
+
+// LoadConfig creates a GelfWriter configured by a JSON or YAML file,
+// the format is selected by the extension of the file. The options
+// are applied after the configuration.
+func LoadConfig(path string, opts ...Option) (*GelfWriter, error) {
+	c, err := ReadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewWriter(opts...)
+}
+
+// ReadConfig reads a configuration from a JSON (.json) or YAML
+// (.yaml, .yml) file.
+func ReadConfig(path string) (Config, error) {
+	var c Config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(b, &c)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &c)
+	default:
+		return c, fmt.Errorf("unknown config file format: %s", path)
+	}
+	if err != nil {
+		return c, fmt.Errorf("cannot read config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// ConfigFromEnv reads the configuration from the environment, see FromEnv.
+func ConfigFromEnv() (Config, error) {
+	var c Config
+	if path, ok := os.LookupEnv(EnvPrefix + "CONFIG"); ok && path != "" {
+		var err error
+		if c, err = ReadConfig(path); err != nil {
+			return c, err
+		}
+	}
+
+	env := func(name string, fn func(v string) error) error {
+		v, ok := os.LookupEnv(EnvPrefix + name)
+		if !ok || v == "" {
+			return nil
+		}
+		if err := fn(v); err != nil {
+			return fmt.Errorf("invalid value for %s%s: %w", EnvPrefix, name, err)
+		}
+		return nil
+	}
+	str := func(dst *string) func(v string) error {
+		return func(v string) error {
+			*dst = v
+			return nil
+		}
+	}
+	list := func(dst *[]string) func(v string) error {
+		return func(v string) error {
+			*dst = nil
+			for _, e := range strings.Split(v, ",") {
+				if e = strings.TrimSpace(e); e != "" {
+					*dst = append(*dst, e)
+				}
+			}
+			return nil
+		}
+	}
+	boolean := func(dst *bool) func(v string) error {
+		return func(v string) (err error) {
+			*dst, err = strconv.ParseBool(v)
+			return err
+		}
+	}
+	num := func(dst *int) func(v string) error {
+		return func(v string) (err error) {
+			*dst, err = strconv.Atoi(v)
+			return err
+		}
+	}
+
+	for _, e := range []struct {
+		name string
+		fn   func(v string) error
+	}{
+		{"TRANSPORT", str(&c.Transport)},
+		{"HOST", str(&c.Host)},
+		{"SPOOL_DIR", str(&c.SpoolDir)},
+		{"MIN_LEVEL", str(&c.MinLevel)},
+		{"ID_POLICY", str(&c.IdPolicy)},
+		{"KEY_NORMALIZATION", str(&c.KeyNormalization)},
+		{"DENY_FIELDS", list(&c.DenyFields)},
+		{"ALLOW_FIELDS", list(&c.AllowFields)},
+		{"REDACT", list(&c.Redact)},
+		{"SHORT_MESSAGE_LENGTH", num(&c.ShortLength)},
+		{"STACK_IN_FULL_MESSAGE", boolean(&c.StackInFull)},
+		{"MAX_FIELD_LENGTH", num(&c.MaxFieldLength)},
+		{"MAX_MESSAGE_SIZE", num(&c.MaxMessageSize)},
+		{"OVERFLOW", str(&c.Overflow)},
+		{"FLUSH_INTERVAL", str(&c.FlushInterval)},
+		{"QUEUE_SIZE", num(&c.QueueSize)},
+		{"WORKERS", num(&c.Workers)},
+		{"STATIC_FIELDS", func(v string) error {
+			pairs, err := parsePairs(v)
+			if err != nil {
+				return err
+			}
+			if c.StaticFields == nil {
+				c.StaticFields = make(map[string]interface{}, len(pairs))
+			}
+			for k, p := range pairs {
+				c.StaticFields[k] = p
+			}
+			return nil
+		}},
+		{"FIELD_MAPPING", func(v string) error {
+			pairs, err := parsePairs(v)
+			if err != nil {
+				return err
+			}
+			if c.FieldMapping == nil {
+				c.FieldMapping = make(map[string]string, len(pairs))
+			}
+			for k, p := range pairs {
+				c.FieldMapping[k] = p
+			}
+			return nil
+		}},
+		{"LEVEL_MAPPING", func(v string) error {
+			pairs, err := parsePairs(v)
+			if err != nil {
+				return err
+			}
+			if c.LevelMapping == nil {
+				c.LevelMapping = make(map[string]int, len(pairs))
+			}
+			for k, p := range pairs {
+				if c.LevelMapping[k], err = strconv.Atoi(p); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	} {
+		if err := env(e.name, e.fn); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// Options converts the configuration to the options of New.
+func (c Config) Options() ([]Option, error) {
+	var opts []Option
+	if c.Host != "" {
+		opts = append(opts, WithHost(c.Host))
+	}
+	if c.SpoolDir != "" {
+		opts = append(opts, WithSpoolDir(c.SpoolDir))
+	}
+	if len(c.StaticFields) > 0 {
+		opts = append(opts, WithStaticFields(c.StaticFields))
+	}
+	if len(c.FieldMapping) > 0 {
+		opts = append(opts, WithFieldMapping(c.FieldMapping))
+	}
+	if c.MinLevel != "" {
+		l, err := zerolog.ParseLevel(c.MinLevel)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithMinLevel(l))
+	}
+	if len(c.LevelMapping) > 0 {
+		mapping := make(map[zerolog.Level]int, len(c.LevelMapping))
+		for k, v := range c.LevelMapping {
+			l, err := zerolog.ParseLevel(k)
+			if err != nil {
+				return nil, err
+			}
+			mapping[l] = v
+		}
+		opts = append(opts, WithLevelMapping(mapping))
+	}
+	if c.IdPolicy != "" {
+		opts = append(opts, WithIdPolicy(IdPolicy(c.IdPolicy)))
+	}
+	if c.KeyNormalization != "" {
+		normalize, ok := map[string]KeyNormalizer{
+			"keep":       KeepKeys,
+			"snake_case": SnakeCaseKeys,
+			"lower_case": LowerCaseKeys,
+		}[c.KeyNormalization]
+		if !ok {
+			return nil, fmt.Errorf("unknown key normalization: %s", c.KeyNormalization)
+		}
+		opts = append(opts, WithKeyNormalizer(normalize))
+	}
+	if len(c.DenyFields) > 0 {
+		opts = append(opts, WithDenyFields(c.DenyFields...))
+	}
+	if len(c.AllowFields) > 0 {
+		opts = append(opts, WithAllowFields(c.AllowFields...))
+	}
+	for _, name := range c.Redact {
+		rule, ok := map[string]RedactionRule{
+			"email":        RedactEmail,
+			"credit_card":  RedactCreditCard,
+			"bearer_token": RedactBearerToken,
+		}[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redaction rule: %s", name)
+		}
+		opts = append(opts, WithRedaction(rule))
+	}
+	if c.ShortLength != 0 {
+		opts = append(opts, WithShortMessageLength(c.ShortLength))
+	}
+	if c.StackInFull {
+		opts = append(opts, WithStackInFullMessage(true))
+	}
+	if c.MaxFieldLength != 0 {
+		opts = append(opts, WithMaxFieldLength(c.MaxFieldLength))
+	}
+	if c.MaxMessageSize != 0 {
+		opts = append(opts, WithMaxMessageSize(c.MaxMessageSize))
+	}
+	if c.QueueSize != 0 {
+		opts = append(opts, WithQueueSize(c.QueueSize))
+	}
+	if c.Overflow != "" {
+		opts = append(opts, WithOverflowPolicy(OverflowPolicy(c.Overflow)))
+	}
+	if c.Workers != 0 {
+		opts = append(opts, WithWorkers(c.Workers))
+	}
+	if c.FlushInterval != "" {
+		d, err := time.ParseDuration(c.FlushInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flush interval: %w", err)
+		}
+		opts = append(opts, WithFlushInterval(d))
+	}
+	return opts, nil
+}
+
+// NewWriter creates the transport and the GelfWriter described by the
+// configuration, the options are applied after the configuration.
+func (c Config) NewWriter(opts ...Option) (*GelfWriter, error) {
+	if c.Transport == "" {
+		return nil, fmt.Errorf("no transport configured")
+	}
+	t, err := ParseTransportURL(c.Transport)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := c.Options()
+	if err != nil {
+		return nil, err
+	}
+	return New(t, append(o, opts...)...)
+}
+
+// parsePairs parses a list like `a=1,b=2`.
+func parsePairs(s string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, p := range strings.Split(s, ",") {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(p, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid key value pair: %q", p)
+		}
+		pairs[k] = strings.TrimSpace(v)
+	}
+	return pairs, nil
+}
@@ -0,0 +1,175 @@
+package zgelf
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	want := Config{
+		Transport:     "gelf+udp://127.0.0.1:12201?compress=gzip",
+		Host:          "web-01",
+		SpoolDir:      "/var/spool/zgelf",
+		StaticFields:  map[string]interface{}{"environment": "production"},
+		FieldMapping:  map[string]string{"req": "request_id"},
+		MinLevel:      "info",
+		QueueSize:     1000,
+		Overflow:      "drop_oldest",
+		Workers:       4,
+		FlushInterval: "5s",
+	}
+
+	files := map[string]string{
+		"zgelf.json": `{
+  "transport": "gelf+udp://127.0.0.1:12201?compress=gzip",
+  "host": "web-01",
+  "spool_dir": "/var/spool/zgelf",
+  "static_fields": {"environment": "production"},
+  "field_mapping": {"req": "request_id"},
+  "min_level": "info",
+  "queue_size": 1000,
+  "overflow": "drop_oldest",
+  "workers": 4,
+  "flush_interval": "5s"
+}`,
+		"zgelf.yaml": `transport: gelf+udp://127.0.0.1:12201?compress=gzip
+host: web-01
+spool_dir: /var/spool/zgelf
+static_fields:
+  environment: production
+field_mapping:
+  req: request_id
+min_level: info
+queue_size: 1000
+overflow: drop_oldest
+workers: 4
+flush_interval: 5s
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadConfig(path)
+			if err != nil {
+				t.Fatalf("ReadConfig() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ReadConfig() = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	if _, err := ReadConfig(filepath.Join(dir, "zgelf.toml")); err == nil {
+		t.Errorf("ReadConfig() want error for unknown format")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zgelf.json")
+	if err := os.WriteFile(path, []byte(`{"host":"file","workers":2,"static_fields":{"a":"1"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("ZGELF_CONFIG", path)
+	t.Setenv("ZGELF_TRANSPORT", "gelf+tcp://127.0.0.1:12201")
+	t.Setenv("ZGELF_HOST", "env")
+	t.Setenv("ZGELF_STATIC_FIELDS", "environment=production, service = api")
+	t.Setenv("ZGELF_FIELD_MAPPING", "req=request_id")
+	t.Setenv("ZGELF_QUEUE_SIZE", "100")
+	t.Setenv("ZGELF_LEVEL_MAPPING", "warn=5")
+	t.Setenv("ZGELF_ID_POLICY", "drop_field")
+	t.Setenv("ZGELF_KEY_NORMALIZATION", "keep")
+	t.Setenv("ZGELF_DENY_FIELDS", "password, *_token")
+	t.Setenv("ZGELF_REDACT", "email,bearer_token")
+	t.Setenv("ZGELF_STACK_IN_FULL_MESSAGE", "true")
+
+	got, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("ConfigFromEnv() error = %v", err)
+	}
+	want := Config{
+		Transport:        "gelf+tcp://127.0.0.1:12201",
+		Host:             "env",
+		Workers:          2,
+		QueueSize:        100,
+		StaticFields:     map[string]interface{}{"a": "1", "environment": "production", "service": "api"},
+		FieldMapping:     map[string]string{"req": "request_id"},
+		LevelMapping:     map[string]int{"warn": 5},
+		IdPolicy:         "drop_field",
+		KeyNormalization: "keep",
+		DenyFields:       []string{"password", "*_token"},
+		Redact:           []string{"email", "bearer_token"},
+		StackInFull:      true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ConfigFromEnv() = %+v, want %+v", got, want)
+	}
+
+	t.Setenv("ZGELF_WORKERS", "many")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Errorf("ConfigFromEnv() want error for invalid number")
+	}
+	t.Setenv("ZGELF_WORKERS", "")
+	t.Setenv("ZGELF_STATIC_FIELDS", "environment")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Errorf("ConfigFromEnv() want error for invalid pair")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("ZGELF_TRANSPORT", "gelf+udp://127.0.0.1:12201")
+	t.Setenv("ZGELF_HOST", "env")
+	w, err := FromEnv()
+	if err != nil {
+		t.Fatalf("FromEnv() error = %v", err)
+	}
+	defer w.Close()
+	if _, ok := w.transport.(*UdpTransport); !ok || w.opts.host != "env" {
+		t.Errorf("unexpected writer: %T %+v", w.transport, w.opts)
+	}
+
+	t.Setenv("ZGELF_TRANSPORT", "")
+	if _, err := FromEnv(); err == nil {
+		t.Errorf("FromEnv() want error without transport")
+	}
+}
+
+func TestConfig_Options(t *testing.T) {
+	c := Config{
+		FieldMapping: map[string]string{"req": "request_id"},
+		MinLevel:     "info",
+	}
+	opts, err := c.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := &testTransport{}
+	w := newTestWriter(t, tr, opts...)
+	_, _ = w.Write([]byte(`{"level":"debug","message":"filtered"}`))
+	_, _ = w.Write([]byte(`{"level":"info","message":"one","req":"abc"}`))
+	w.Close()
+
+	sent := tr.sent()
+	if len(sent) != 1 || sent[0]["_request_id"] != "abc" || sent[0]["_req"] != nil {
+		t.Errorf("unexpected messages: %v", sent)
+	}
+
+	for _, c := range []Config{
+		{MinLevel: "verbose"},
+		{FlushInterval: "soon"},
+		{LevelMapping: map[string]int{"verbose": 7}},
+		{KeyNormalization: "camel_case"},
+		{Redact: []string{"phone"}},
+	} {
+		if _, err := c.Options(); err == nil {
+			t.Errorf("Options() want error for %+v", c)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package zgelf
+
+import (
+	"compress/flate"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultGelfPort = "12201"
+
+// ParseTransportURL creates a transport from an url, the scheme selects the
+// transport and the query parameters configure it:
+//
+//	gelf+udp://graylog:12201?compress=gzip&level=9&chunk=lan
+//	gelf+tcp://graylog:12201?batch=64k&interval=5s&timeout=10s
+//	gelf+tcp+tls://graylog:12202?ca=/etc/ca.pem&cert=/etc/client.pem&key=/etc/client.key
+//	gelf+http://graylog:12201/gelf?batch=64k&interval=5s&timeout=10s
+//
+// The prefix `gelf+` is optional, the port defaults to 12201 for udp and tcp.
+// The chunk size is either a size in bytes or `wan` (1420) or `lan` (8154).
+// The tls transport additionally accepts `servername`, `minversion` (1.2, 1.3)
+// and `insecure`. Unknown parameters are reported as error.
+func ParseTransportURL(dsn string) (transport, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing host in transport url: %s", dsn)
+	}
+	q := u.Query()
+
+	switch strings.TrimPrefix(u.Scheme, "gelf+") {
+	case "udp":
+		return parseUdpURL(u, q)
+	case "tcp":
+		return parseTcpURL(u, q, nil)
+	case "tcp+tls", "tls":
+		return parseTcpURL(u, q, &TlsConfig{})
+	case "http", "https":
+		return parseHttpURL(u, q)
+	default:
+		return nil, fmt.Errorf("unknown transport scheme: %s", u.Scheme)
+	}
+}
+
+func parseUdpURL(u *url.URL, q url.Values) (transport, error) {
+	t, err := NewUdpTransport(hostPort(u))
+	if err != nil {
+		return nil, err
+	}
+
+	compression := CompressionNone
+	level := flate.DefaultCompression
+	for k := range q {
+		v := q.Get(k)
+		switch k {
+		case "compress":
+			compression = Compression(v)
+		case "level":
+			if level, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("invalid compression level: %s", v)
+			}
+		case "chunk":
+			size, err := parseChunkSize(v)
+			if err != nil {
+				return nil, err
+			}
+			if err := t.SetChunkSize(size); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, unknownParameter(k, u.Scheme)
+		}
+	}
+	if err := t.SetCompression(compression, level); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseTcpURL(u *url.URL, q url.Values, tlsConfig *TlsConfig) (transport, error) {
+	var err error
+	if tlsConfig != nil {
+		for k := range q {
+			v := q.Get(k)
+			switch k {
+			case "ca":
+				tlsConfig.CAFile = v
+			case "cert":
+				tlsConfig.CertFile = v
+			case "key":
+				tlsConfig.KeyFile = v
+			case "servername":
+				tlsConfig.ServerName = v
+			case "minversion":
+				if tlsConfig.MinVersion, err = parseTlsVersion(v); err != nil {
+					return nil, err
+				}
+			case "insecure":
+				if tlsConfig.InsecureSkipVerify, err = strconv.ParseBool(v); err != nil {
+					return nil, fmt.Errorf("invalid value for insecure: %s", v)
+				}
+			default:
+				continue
+			}
+			q.Del(k)
+		}
+	}
+
+	var t *TcpTransport
+	if tlsConfig != nil {
+		t, err = NewTlsTransport(hostPort(u), *tlsConfig)
+	} else {
+		t, err = NewTcpTransport(hostPort(u))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := parseBufferParameters(u, q, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseHttpURL(u *url.URL, q url.Values) (transport, error) {
+	target := url.URL{
+		Scheme: strings.TrimPrefix(u.Scheme, "gelf+"),
+		Host:   u.Host,
+		Path:   u.Path,
+	}
+	t, err := NewHttpTransport(target.String(), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := parseBufferParameters(u, q, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+type bufferedTransport interface {
+	SetBufferSize(size int)
+	SetBufferTime(d time.Duration)
+	SetTimeout(timeout time.Duration)
+}
+
+// parseBufferParameters applies the parameters common to
+// the buffered transports (tcp and http).
+func parseBufferParameters(u *url.URL, q url.Values, t bufferedTransport) error {
+	for k := range q {
+		v := q.Get(k)
+		switch k {
+		case "batch":
+			size, err := parseSize(v)
+			if err != nil {
+				return err
+			}
+			t.SetBufferSize(size)
+		case "interval":
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid interval: %s", v)
+			}
+			t.SetBufferTime(d)
+		case "timeout":
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid timeout: %s", v)
+			}
+			t.SetTimeout(d)
+		default:
+			return unknownParameter(k, u.Scheme)
+		}
+	}
+	return nil
+}
+
+// hostPort returns host:port of the url, the port defaults to 12201.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultGelfPort
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// parseSize parses a size in bytes with an optional unit k, m or g (1024 based).
+func parseSize(s string) (int, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "b")
+	m := 1
+	switch {
+	case strings.HasSuffix(v, "k"):
+		m = 1024
+	case strings.HasSuffix(v, "m"):
+		m = 1024 * 1024
+	case strings.HasSuffix(v, "g"):
+		m = 1024 * 1024 * 1024
+	}
+	if m > 1 {
+		v = v[:len(v)-1]
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * m, nil
+}
+
+// parseChunkSize parses a chunk size, which is either `wan`, `lan` or a size.
+func parseChunkSize(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "wan":
+		return ChunkSizeWAN, nil
+	case "lan":
+		return ChunkSizeLAN, nil
+	default:
+		return parseSize(s)
+	}
+}
+
+func parseTlsVersion(s string) (uint16, error) {
+	switch s {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("invalid tls version: %s", s)
+	}
+}
+
+func unknownParameter(name, scheme string) error {
+	return fmt.Errorf("unknown parameter %q for transport %s", name, scheme)
+}
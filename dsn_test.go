@@ -0,0 +1,107 @@
+package zgelf
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestParseTransportURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsn     string
+		check   func(t *testing.T, tr transport)
+		wantErr bool
+	}{
+		{"udp", "gelf+udp://127.0.0.1:12201?compress=gzip&level=9&chunk=8154", func(t *testing.T, tr transport) {
+			u := tr.(*UdpTransport)
+			if u.compression != CompressionGzip || u.level != 9 || u.chunkSize != 8154 {
+				t.Errorf("unexpected udp transport: %+v", u)
+			}
+		}, false},
+		{"udp lan chunks", "udp://127.0.0.1?chunk=lan", func(t *testing.T, tr transport) {
+			if c := tr.(*UdpTransport).chunkSize; c != ChunkSizeLAN {
+				t.Errorf("chunk size = %d, want %d", c, ChunkSizeLAN)
+			}
+		}, false},
+		{"udp default port", "udp://127.0.0.1", func(t *testing.T, tr transport) {
+			if p := tr.(*UdpTransport).serverAddr.Port; p != 12201 {
+				t.Errorf("port = %d, want 12201", p)
+			}
+		}, false},
+		{"tcp", "gelf+tcp://127.0.0.1:12201?batch=64k&interval=5s&timeout=3s", func(t *testing.T, tr transport) {
+			c := tr.(*TcpTransport)
+			if c.bufferSize != 64*1024 || c.duration != time.Second*5 || c.timeout != time.Second*3 || c.tlsConfig != nil {
+				t.Errorf("unexpected tcp transport: %+v", c)
+			}
+		}, false},
+		{"tls", "gelf+tcp+tls://127.0.0.1:12202?servername=graylog&minversion=1.3&insecure=true&batch=1m", func(t *testing.T, tr transport) {
+			c := tr.(*TcpTransport)
+			if c.tlsConfig == nil || c.tlsConfig.ServerName != "graylog" || c.tlsConfig.MinVersion != tls.VersionTLS13 ||
+				!c.tlsConfig.InsecureSkipVerify || c.bufferSize != 1024*1024 {
+				t.Errorf("unexpected tls transport: %+v", c)
+			}
+		}, false},
+		{"http", "gelf+http://127.0.0.1:12201/gelf?batch=64k&interval=5s", func(t *testing.T, tr transport) {
+			h := tr.(*HttpTransport)
+			if h.url != "http://127.0.0.1:12201/gelf" || h.bufferSize != 64*1024 || h.duration != time.Second*5 {
+				t.Errorf("unexpected http transport: %+v", h)
+			}
+		}, false},
+		{"https default path", "gelf+https://graylog.local", func(t *testing.T, tr transport) {
+			if u := tr.(*HttpTransport).url; u != "https://graylog.local/gelf" {
+				t.Errorf("url = %s", u)
+			}
+		}, false},
+		{"unknown scheme", "gelf+smtp://127.0.0.1", nil, true},
+		{"missing host", "gelf+udp://", nil, true},
+		{"unknown udp parameter", "gelf+udp://127.0.0.1?batch=64k", nil, true},
+		{"unknown tcp parameter", "gelf+tcp://127.0.0.1?ca=/etc/ca.pem", nil, true},
+		{"unknown http parameter", "gelf+http://127.0.0.1?compress=gzip", nil, true},
+		{"invalid compression", "gelf+udp://127.0.0.1?compress=lz4", nil, true},
+		{"invalid chunk", "gelf+udp://127.0.0.1?chunk=8", nil, true},
+		{"invalid batch", "gelf+tcp://127.0.0.1?batch=lots", nil, true},
+		{"invalid interval", "gelf+http://127.0.0.1?interval=-5s", nil, true},
+		{"invalid tls version", "gelf+tcp+tls://127.0.0.1?minversion=2.0", nil, true},
+		{"missing ca file", "gelf+tcp+tls://127.0.0.1?ca=/nonexistent/ca.pem", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTransportURL(tt.dsn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTransportURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, got)
+			}
+		})
+	}
+}
+
+func Test_parseSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int
+		wantErr bool
+	}{
+		{"512", 512, false},
+		{"64k", 64 * 1024, false},
+		{"64KB", 64 * 1024, false},
+		{"2m", 2 * 1024 * 1024, false},
+		{"1g", 1024 * 1024 * 1024, false},
+		{"0", 0, true},
+		{"k", 0, true},
+		{"-1k", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseSize(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
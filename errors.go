@@ -0,0 +1,149 @@
+package zgelf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrorMessageTooLarge is reported for a message, which exceeds the
+// size a transport can send. The message is not saved for a retry.
+var ErrorMessageTooLarge = errors.New("message too large")
+
+// ErrorMessageRejected is reported for a message, which the server refuses
+// permanently, e.g. because it is malformed. The message is not saved for
+// a retry.
+var ErrorMessageRejected = errors.New("message rejected")
+
+type ErrorKind string
+
+const (
+	// ErrorKindDecode is reported, if an event is not valid JSON or
+	// cannot be converted to a GELF message.
+	ErrorKindDecode = ErrorKind("decode")
+	// ErrorKindMarshal is reported, if a GELF message cannot be serialized.
+	ErrorKindMarshal = ErrorKind("marshal")
+	// ErrorKindSend is reported, if the transport fails to send a buffer.
+	ErrorKindSend = ErrorKind("send")
+	// ErrorKindChunk is reported, if a chunk of an udp message cannot be sent.
+	ErrorKindChunk = ErrorKind("chunk")
+	// ErrorKindSpool is reported, if the temporary logs cannot be
+	// written or read.
+	ErrorKindSpool = ErrorKind("spool")
+)
+
+// Error is passed to the ErrorHandler, it wraps the error which occurred.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s error: %s", e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// SendError is returned by a transport, if single messages of a buffer
+// could not be sent, while the others were sent.
+type SendError struct {
+	// Failed holds the messages, which may be sent again later on.
+	Failed [][]byte
+	// Rejected holds the messages, which can never be sent,
+	// e.g. because they are too large or refused by the server.
+	Rejected [][]byte
+	// Errors holds the error of every message not sent.
+	Errors []error
+}
+
+// add records the failure of a message.
+func (e *SendError) add(d []byte, err error) {
+	if errors.Is(err, ErrorMessageTooLarge) || errors.Is(err, ErrorMessageRejected) {
+		e.Rejected = append(e.Rejected, d)
+	} else {
+		e.Failed = append(e.Failed, d)
+	}
+	e.Errors = append(e.Errors, err)
+}
+
+func (e *SendError) Error() string {
+	if len(e.Errors) == 0 {
+		return "no messages failed"
+	}
+	return fmt.Sprintf("%d messages not sent, first error: %s", len(e.Errors), e.Errors[0])
+}
+
+func (e *SendError) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors[0]
+}
+
+// ErrorHandler receives all errors, which occur in the background.
+// It is called concurrently and must not block.
+type ErrorHandler func(err *Error)
+
+// SetErrorHandler sets the handler for errors which occur in the background,
+// by default they are discarded. Passing nil restores the default.
+func (w *GelfWriter) SetErrorHandler(handler ErrorHandler) {
+	w.errorHandler.Store(&handler)
+}
+
+// handleError passes the error to the error handler. An *Error returned
+// by a transport keeps its kind, other errors get the kind given.
+func (w *GelfWriter) handleError(kind ErrorKind, err error) {
+	h, ok := w.errorHandler.Load().(*ErrorHandler)
+	if !ok || *h == nil {
+		return
+	}
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Kind: kind, Err: err}
+	}
+	(*h)(e)
+}
+
+// DeadLetterHandler receives the events, which cannot be decoded or converted
+// to a GELF message, together with the reason. It is called concurrently.
+type DeadLetterHandler func(event []byte, err error)
+
+// deadLetter passes an event, which cannot be processed, to the dead-letter
+// handler. Without a handler, it returns a GELF message describing the
+// problem, which contains the original event as full message, unless
+// fields are filtered by a deny or allow list.
+func (w *GelfWriter) deadLetter(event []byte, err error) []byte {
+	w.handleError(ErrorKindDecode, err)
+	if w.opts.deadLetterHandler != nil {
+		w.opts.deadLetterHandler(event, err)
+		return nil
+	}
+
+	evn := map[string]interface{}{
+		VersionFieldName:         GelfVersion,
+		HostFieldName:            w.opts.host,
+		ShortMessageFieldName:    "cannot process log event: " + err.Error(),
+		TimestampFieldName:       unixSeconds(time.Now()),
+		LevelFieldName:           3,
+		ProcessingErrorFieldName: err.Error(),
+	}
+	if len(w.opts.denyFields) == 0 && len(w.opts.allowFields) == 0 {
+		evn[FullMessageFieldName] = string(event)
+	}
+	// the static fields are flattened and formatted by New
+	for k, v := range w.opts.staticFields {
+		if _, ok := evn[k]; !ok {
+			evn[k] = v
+		}
+	}
+	w.protect(evn)
+	d, err := json.Marshal(evn)
+	if err != nil {
+		w.handleError(ErrorKindMarshal, err)
+		return nil
+	}
+	return d
+}
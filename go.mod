@@ -2,4 +2,13 @@ module github.com/rverst/zgelf
 
 go 1.19
 
-require github.com/rs/zerolog v1.27.0
+require (
+	github.com/rs/zerolog v1.28.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/mattn/go-colorable v0.1.12 // indirect
+	github.com/mattn/go-isatty v0.0.14 // indirect
+	golang.org/x/sys v0.0.0-20210927094055-39ccf1dd6fa6 // indirect
+)
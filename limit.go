@@ -0,0 +1,103 @@
+package zgelf
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// limitFields cuts the string values of the additional fields and the full
+// message, which are longer than the max field length, and marks them in
+// the field `_truncated`.
+func (w *GelfWriter) limitFields(evn map[string]interface{}) {
+	max := w.opts.maxFieldLength
+	if max <= 0 {
+		return
+	}
+	var truncated []string
+	for k, v := range evn {
+		s, ok := v.(string)
+		if !ok || !truncatable(k) || len(s) <= max {
+			continue
+		}
+		evn[k] = truncate(s, max)
+		truncated = append(truncated, k)
+	}
+	markTruncated(evn, truncated...)
+}
+
+// shrink cuts the largest string values of the message, until the serialized
+// message fits into the max message size. Values, which would be cut
+// completely, are removed. The message is returned unchanged, if it cannot
+// be shrunk any further.
+func (w *GelfWriter) shrink(evn map[string]interface{}, d []byte) ([]byte, error) {
+	var err error
+	for len(d) > w.opts.maxMessageSize {
+		key, size := "", -1
+		for k, v := range evn {
+			s, ok := v.(string)
+			if !ok || !truncatable(k) {
+				continue
+			}
+			if len(s) > size || len(s) == size && k < key {
+				key, size = k, len(s)
+			}
+		}
+		if key == "" {
+			break
+		}
+
+		// mark the field first, so the size of the marker is considered
+		markTruncated(evn, key)
+		if d, err = json.Marshal(evn); err != nil {
+			return nil, err
+		}
+		n := size - (len(d) - w.opts.maxMessageSize)
+		if n <= 0 {
+			delete(evn, key)
+		} else {
+			evn[key] = truncate(evn[key].(string), n)
+		}
+		if d, err = json.Marshal(evn); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// truncatable reports if the field may be cut to fit the limits.
+func truncatable(key string) bool {
+	return key == FullMessageFieldName ||
+		strings.HasPrefix(key, "_") && key != TruncatedFieldName
+}
+
+// truncate cuts `s` to at most `n` bytes, without splitting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// markTruncated adds the names to the sorted list of truncated fields.
+func markTruncated(evn map[string]interface{}, names ...string) {
+	if len(names) == 0 {
+		return
+	}
+	if m, ok := evn[TruncatedFieldName].(string); ok && m != "" {
+		names = append(names, strings.Split(m, ",")...)
+	}
+	sort.Strings(names)
+
+	list := names[:0]
+	for i, n := range names {
+		if i == 0 || n != names[i-1] {
+			list = append(list, n)
+		}
+	}
+	evn[TruncatedFieldName] = strings.Join(list, ",")
+}
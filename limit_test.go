@@ -0,0 +1,94 @@
+package zgelf
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_truncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"shorter", "abc", 5, "abc"},
+		{"cut", "abcdef", 3, "abc"},
+		{"multi byte", "aäb", 2, "a"},
+		{"zero", "abc", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncate(tt.s, tt.n); got != tt.want {
+				t.Errorf("truncate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_markTruncated(t *testing.T) {
+	evn := map[string]interface{}{}
+	markTruncated(evn)
+	if _, ok := evn[TruncatedFieldName]; ok {
+		t.Errorf("unexpected marker: %v", evn)
+	}
+	markTruncated(evn, "_b", "full_message")
+	markTruncated(evn, "_a", "_b")
+	if evn[TruncatedFieldName] != "_a,_b,full_message" {
+		t.Errorf("marker = %v", evn[TruncatedFieldName])
+	}
+}
+
+func TestGelfWriter_limits(t *testing.T) {
+	body := strings.Repeat("x", 5000)
+	event := `{"level":"info","message":"request\n` + strings.Repeat("y", 500) + `",` +
+		`"body":"` + body + `","path":"/api/users","status":200}`
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		maxSize int
+		want    map[string]interface{}
+	}{
+		{"field length", []Option{WithMaxFieldLength(100)}, 0, map[string]interface{}{
+			"_body":            body[:100],
+			"_path":            "/api/users",
+			TruncatedFieldName: "_body,full_message",
+		}},
+		{"message size", []Option{WithMaxMessageSize(1024)}, 1024, map[string]interface{}{
+			"_path":            "/api/users",
+			"_status":          float64(200),
+			TruncatedFieldName: "_body",
+		}},
+		{"message size removes fields", []Option{WithMaxMessageSize(300)}, 300, map[string]interface{}{
+			ShortMessageFieldName: "request",
+			"_path":               "/api/users",
+			TruncatedFieldName:    "_body,full_message",
+		}},
+		{"unlimited", nil, 0, map[string]interface{}{
+			"_body": body,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &testTransport{}
+			w := newTestWriter(t, tr, tt.opts...)
+			_, _ = w.Write([]byte(event))
+			w.Close()
+
+			sent := tr.sent()
+			if len(sent) != 1 {
+				t.Fatalf("want 1 message, got: %v", sent)
+			}
+			for k, v := range tt.want {
+				if sent[0][k] != v {
+					t.Errorf("field %s = %.40v, want %.40v", k, sent[0][k], v)
+				}
+			}
+			if d, _ := json.Marshal(sent[0]); tt.maxSize > 0 && len(d) > tt.maxSize {
+				t.Errorf("message size %d exceeds %d", len(d), tt.maxSize)
+			}
+		})
+	}
+}
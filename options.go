@@ -0,0 +1,362 @@
+package zgelf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// options holds the configuration of a GelfWriter.
+type options struct {
+	host              string
+	spoolDir          string
+	spoolLimits       SpoolLimits
+	spoolCompression  bool
+	queueSize         int
+	overflowPolicy    OverflowPolicy
+	workers           int
+	preserveOrder     bool
+	flushInterval     time.Duration
+	errorHandler      ErrorHandler
+	staticFields      map[string]interface{}
+	fieldMapping      map[string]string
+	minLevel          zerolog.Level
+	levelMapping      map[zerolog.Level]int
+	deadLetterHandler DeadLetterHandler
+	idPolicy          IdPolicy
+	maxDepth          int
+	arraysAsJSON      bool
+	keyNormalizer     KeyNormalizer
+	dynamicFields     []DynamicFields
+	denyFields        []string
+	allowFields       []string
+	redactionRules    []RedactionRule
+	shortLength       int
+	stackInFull       bool
+	maxFieldLength    int
+	maxMessageSize    int
+}
+
+// Option configures a GelfWriter, see New.
+type Option func(o *options) error
+
+// WithHost sets the `host` field of the GELF messages,
+// it defaults to the hostname of the system.
+func WithHost(host string) Option {
+	return func(o *options) error {
+		if host == "" {
+			return fmt.Errorf("host must not be empty")
+		}
+		o.host = host
+		return nil
+	}
+}
+
+// WithSpoolDir sets the directory, in which logs are saved
+// if they cannot be sent. They are sent again, once the
+// transport succeeds.
+func WithSpoolDir(dir string) Option {
+	return func(o *options) error {
+		o.spoolDir = dir
+		return nil
+	}
+}
+
+// WithSpoolLimits restricts the size, number and age of the saved logs.
+func WithSpoolLimits(limits SpoolLimits) Option {
+	return func(o *options) error {
+		o.spoolLimits = limits
+		return nil
+	}
+}
+
+// WithSpoolCompression enables or disables the gzip compression
+// of the saved logs, it is enabled by default.
+func WithSpoolCompression(enable bool) Option {
+	return func(o *options) error {
+		o.spoolCompression = enable
+		return nil
+	}
+}
+
+// WithQueueSize sets the number of events, which are queued for
+// processing, it defaults to 500.
+func WithQueueSize(size int) Option {
+	return func(o *options) error {
+		if size < 1 {
+			return fmt.Errorf("invalid queue size: %d", size)
+		}
+		o.queueSize = size
+		return nil
+	}
+}
+
+// WithOverflowPolicy sets the handling of events, which do not fit
+// into the queue, it defaults to OverflowBlock.
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(o *options) error {
+		if err := validateOverflowPolicy(policy); err != nil {
+			return err
+		}
+		o.overflowPolicy = policy
+		return nil
+	}
+}
+
+// WithWorkers sets the number of routines, which process the events
+// concurrently, it defaults to GOMAXPROCS.
+func WithWorkers(n int) Option {
+	return func(o *options) error {
+		if n < 1 {
+			return fmt.Errorf("invalid number of workers: %d", n)
+		}
+		o.workers = n
+		return nil
+	}
+}
+
+// WithPreserveOrder defines, if the messages are sent in the order they
+// were written, it is enabled by default.
+func WithPreserveOrder(preserve bool) Option {
+	return func(o *options) error {
+		o.preserveOrder = preserve
+		return nil
+	}
+}
+
+// WithFlushInterval sets the time after the buffer is flushed, regardless
+// of its size. It defaults to the buffer time of the transport.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid flush interval: %s", interval)
+		}
+		o.flushInterval = interval
+		return nil
+	}
+}
+
+// WithErrorHandler sets the handler for errors which occur in the
+// background, by default they are discarded.
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(o *options) error {
+		o.errorHandler = handler
+		return nil
+	}
+}
+
+// WithDeadLetterHandler sets the handler for events, which cannot be
+// decoded or converted to a GELF message. By default a GELF message
+// describing the problem is sent instead.
+func WithDeadLetterHandler(handler DeadLetterHandler) Option {
+	return func(o *options) error {
+		o.deadLetterHandler = handler
+		return nil
+	}
+}
+
+// WithIdPolicy sets the handling of the field `id`, which is not allowed
+// in GELF messages. It defaults to IdRename, which sends it as `_orig_id`.
+func WithIdPolicy(policy IdPolicy) Option {
+	return func(o *options) error {
+		if err := validateIdPolicy(policy); err != nil {
+			return err
+		}
+		o.idPolicy = policy
+		return nil
+	}
+}
+
+// WithMaxDepth sets the depth, up to which nested objects and arrays are
+// flattened to fields like `_http.request.method` or `_tags.0`. Deeper
+// values are sent as JSON strings, 0 sends all of them as JSON strings.
+// It defaults to 5.
+func WithMaxDepth(depth int) Option {
+	return func(o *options) error {
+		if depth < 0 {
+			return fmt.Errorf("invalid max depth: %d", depth)
+		}
+		o.maxDepth = depth
+		return nil
+	}
+}
+
+// WithArraysAsJSON sends arrays as JSON strings, instead of
+// flattening them to a field per element.
+func WithArraysAsJSON(enable bool) Option {
+	return func(o *options) error {
+		o.arraysAsJSON = enable
+		return nil
+	}
+}
+
+// WithKeyNormalizer sets the conversion of the field names, e.g. KeepKeys,
+// SnakeCaseKeys or LowerCaseKeys. It defaults to SnakeCaseKeys.
+func WithKeyNormalizer(normalize KeyNormalizer) Option {
+	return func(o *options) error {
+		if normalize == nil {
+			return fmt.Errorf("key normalizer must not be nil")
+		}
+		o.keyNormalizer = normalize
+		return nil
+	}
+}
+
+// WithStaticFields adds the fields to every message. The keys are
+// formatted like the keys of the events, which take precedence.
+func WithStaticFields(fields map[string]interface{}) Option {
+	return func(o *options) error {
+		for k, v := range fields {
+			o.staticFields[k] = v
+		}
+		return nil
+	}
+}
+
+// DynamicFields returns fields, which are added to a message when it is
+// processed, e.g. the number of goroutines. It is called concurrently for
+// every message and must be fast.
+type DynamicFields func() map[string]interface{}
+
+// WithDynamicFields adds the fields returned by `fields` to every message.
+// The keys are formatted like the keys of the events, which take precedence
+// over the dynamic fields, the dynamic fields over the static fields.
+func WithDynamicFields(fields DynamicFields) Option {
+	return func(o *options) error {
+		if fields == nil {
+			return fmt.Errorf("dynamic fields must not be nil")
+		}
+		o.dynamicFields = append(o.dynamicFields, fields)
+		return nil
+	}
+}
+
+// WithDenyFields removes the additional fields matching one of the patterns
+// from every message. The patterns are matched against the names of the
+// additional fields with and without the leading underscore and may contain
+// wildcards, e.g. `password` or `http.request.header.*`.
+func WithDenyFields(patterns ...string) Option {
+	return func(o *options) error {
+		if err := validateFieldPatterns(patterns); err != nil {
+			return err
+		}
+		o.denyFields = append(o.denyFields, patterns...)
+		return nil
+	}
+}
+
+// WithAllowFields removes all additional fields, which match none of the
+// patterns, from every message. The patterns are matched like the patterns
+// of WithDenyFields, the deny list takes precedence.
+func WithAllowFields(patterns ...string) Option {
+	return func(o *options) error {
+		if err := validateFieldPatterns(patterns); err != nil {
+			return err
+		}
+		o.allowFields = append(o.allowFields, patterns...)
+		return nil
+	}
+}
+
+// WithRedaction applies the rules to the string values of the short and full
+// message and the additional fields of every message, e.g. RedactEmail,
+// RedactCreditCard or RedactBearerToken. Events passed to a dead-letter
+// handler are not redacted.
+func WithRedaction(rules ...RedactionRule) Option {
+	return func(o *options) error {
+		for _, r := range rules {
+			if r.Pattern == nil {
+				return fmt.Errorf("redaction rule without pattern")
+			}
+		}
+		o.redactionRules = append(o.redactionRules, rules...)
+		return nil
+	}
+}
+
+// WithShortMessageLength sets the maximum number of characters of the
+// `short_message`, 0 disables the limit. Only the first line of a message
+// is sent as short message, if a message is cut, the whole text is sent
+// as `full_message`. It defaults to 250.
+func WithShortMessageLength(length int) Option {
+	return func(o *options) error {
+		if length < 0 {
+			return fmt.Errorf("invalid short message length: %d", length)
+		}
+		o.shortLength = length
+		return nil
+	}
+}
+
+// WithStackInFullMessage appends the error stack to the `full_message`,
+// instead of sending it as additional field `_err_stack`.
+func WithStackInFullMessage(enable bool) Option {
+	return func(o *options) error {
+		o.stackInFull = enable
+		return nil
+	}
+}
+
+// WithMaxFieldLength cuts the string values of the additional fields and
+// the full message to `length` bytes, 0 disables the limit. The names of
+// the fields cut are sent in the field `_truncated`.
+func WithMaxFieldLength(length int) Option {
+	return func(o *options) error {
+		if length < 0 {
+			return fmt.Errorf("invalid max field length: %d", length)
+		}
+		o.maxFieldLength = length
+		return nil
+	}
+}
+
+// WithMaxMessageSize limits the size of a serialized message in bytes,
+// 0 disables the limit. The largest fields are cut first, the names of
+// the fields cut are sent in the field `_truncated`.
+func WithMaxMessageSize(size int) Option {
+	return func(o *options) error {
+		if size < 0 {
+			return fmt.Errorf("invalid max message size: %d", size)
+		}
+		o.maxMessageSize = size
+		return nil
+	}
+}
+
+// WithFieldMapping renames fields of the events, the keys are the names
+// used in the events, the values the names of the additional fields.
+func WithFieldMapping(mapping map[string]string) Option {
+	return func(o *options) error {
+		for k, v := range mapping {
+			if _, err := formatKey(v, KeepKeys); err != nil {
+				return fmt.Errorf("invalid mapping for field %q: %w", k, err)
+			}
+			o.fieldMapping[k] = v
+		}
+		return nil
+	}
+}
+
+// WithMinLevel drops all events with a level below `level`.
+func WithMinLevel(level zerolog.Level) Option {
+	return func(o *options) error {
+		o.minLevel = level
+		return nil
+	}
+}
+
+// WithLevelMapping overrides the conversion of zerolog levels to syslog
+// severities (0 emergency - 7 debug), e.g. to send `warn` as notice (5).
+// Levels not contained keep their default severity.
+func WithLevelMapping(mapping map[zerolog.Level]int) Option {
+	return func(o *options) error {
+		for l, s := range mapping {
+			if s < 0 || s > 7 {
+				return fmt.Errorf("invalid severity %d for level %s", s, l)
+			}
+			o.levelMapping[l] = s
+		}
+		return nil
+	}
+}
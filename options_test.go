@@ -0,0 +1,194 @@
+package zgelf
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestNew_invalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"empty host", WithHost("")},
+		{"queue size", WithQueueSize(0)},
+		{"overflow policy", WithOverflowPolicy("unknown")},
+		{"workers", WithWorkers(0)},
+		{"flush interval", WithFlushInterval(0)},
+		{"static field id", WithStaticFields(map[string]interface{}{"id": 1})},
+		{"static field empty", WithStaticFields(map[string]interface{}{"": 1})},
+		{"level mapping", WithLevelMapping(map[zerolog.Level]int{zerolog.WarnLevel: 8})},
+		{"id policy", WithIdPolicy("keep")},
+		{"max depth", WithMaxDepth(-1)},
+		{"dynamic fields", WithDynamicFields(nil)},
+		{"deny fields", WithDenyFields("[")},
+		{"allow fields", WithAllowFields("[")},
+		{"redaction", WithRedaction(RedactionRule{})},
+		{"short message length", WithShortMessageLength(-1)},
+		{"max field length", WithMaxFieldLength(-1)},
+		{"max message size", WithMaxMessageSize(-1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(&testTransport{}, tt.opt); err == nil {
+				t.Errorf("New() want error")
+			}
+		})
+	}
+}
+
+func TestNew_options(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "spool")
+	tr := &testTransport{}
+	w, err := New(tr,
+		WithHost("web-01"),
+		WithSpoolDir(dir),
+		WithSpoolCompression(false),
+		WithSpoolLimits(SpoolLimits{MaxFiles: 10}),
+		WithQueueSize(10),
+		WithOverflowPolicy(OverflowDropNewest),
+		WithWorkers(3),
+		WithPreserveOrder(false),
+		WithFlushInterval(time.Second),
+		WithErrorHandler(func(err *Error) {}),
+		WithStaticFields(map[string]interface{}{"environment": "test", "_service": "api"}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if w.opts.host != "web-01" || w.queue.size != 10 || w.queue.policy != OverflowDropNewest ||
+		w.workers != 3 || w.sequencer.preserve || w.spool == nil || w.spool.compress ||
+		w.spool.limits.MaxFiles != 10 {
+		t.Errorf("options not applied: %+v", w.opts)
+	}
+
+	_, _ = w.Write([]byte(`{"level":"info","message":"one","service":"web"}`))
+	w.Close()
+
+	sent := tr.sent()
+	if len(sent) != 1 {
+		t.Fatalf("want 1 message, got: %v", sent)
+	}
+	m := sent[0]
+	if m[HostFieldName] != "web-01" || m["_environment"] != "test" || m["_service"] != "web" {
+		t.Errorf("unexpected message: %v", m)
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	tr := &testTransport{}
+	w := NewWriter("legacy", "", tr)
+	_, _ = w.Write([]byte(`{"level":"info","message":"one"}`))
+	w.Close()
+
+	if sent := tr.sent(); len(sent) != 1 || sent[0][HostFieldName] != "legacy" {
+		t.Errorf("unexpected messages: %v", sent)
+	}
+}
+
+func TestNew_levelMapping(t *testing.T) {
+	tr := &testTransport{}
+	w := newTestWriter(t, tr, WithLevelMapping(map[zerolog.Level]int{
+		zerolog.WarnLevel:  5,
+		zerolog.FatalLevel: 0,
+	}))
+	_, _ = w.Write([]byte(`{"level":"trace","message":"trace"}`))
+	_, _ = w.Write([]byte(`{"level":"warn","message":"warn"}`))
+	_, _ = w.Write([]byte(`{"level":"fatal","message":"fatal"}`))
+	_, _ = w.Write([]byte(`{"message":"none"}`))
+	w.Close()
+
+	want := map[string]struct {
+		level    interface{}
+		logLevel interface{}
+	}{
+		"trace": {float64(7), "trace"},
+		"warn":  {float64(5), "warn"},
+		"fatal": {float64(0), "fatal"},
+		"none":  {float64(6), nil},
+	}
+	sent := tr.sent()
+	if len(sent) != len(want) {
+		t.Fatalf("want %d messages, got: %v", len(want), sent)
+	}
+	for _, m := range sent {
+		w := want[m[ShortMessageFieldName].(string)]
+		if m[LevelFieldName] != w.level || m[LogLevelFieldName] != w.logLevel {
+			t.Errorf("unexpected message: %v", m)
+		}
+	}
+}
+
+func TestNew_idPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy IdPolicy
+		want   []map[string]interface{}
+	}{
+		{"default", "", []map[string]interface{}{{OriginalIdFieldName: "abc"}}},
+		{"rename", IdRename, []map[string]interface{}{{OriginalIdFieldName: "abc"}}},
+		{"drop field", IdDropField, []map[string]interface{}{{}}},
+		{"drop event", IdDropEvent, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []Option
+			if tt.policy != "" {
+				opts = append(opts, WithIdPolicy(tt.policy))
+			}
+			tr := &testTransport{}
+			w := newTestWriter(t, tr, opts...)
+			_, _ = w.Write([]byte(`{"level":"info","message":"one","id":"abc"}`))
+			w.Close()
+
+			sent := tr.sent()
+			if len(sent) != len(tt.want) {
+				t.Fatalf("want %d messages, got: %v", len(tt.want), sent)
+			}
+			for i, m := range sent {
+				if m[NotAllowedIdFieldName] != nil || m["id"] != nil ||
+					m[OriginalIdFieldName] != tt.want[i][OriginalIdFieldName] {
+					t.Errorf("unexpected message: %v", m)
+				}
+			}
+		})
+	}
+}
+
+func TestNew_dynamicFields(t *testing.T) {
+	var calls int64
+	tr := &testTransport{}
+	w := newTestWriter(t, tr,
+		WithStaticFields(map[string]interface{}{"environment": "test", "service": "api", "version": "1.0",
+			"deploy": map[string]interface{}{"git sha": "def"}}),
+		WithDynamicFields(func() map[string]interface{} {
+			n := atomic.AddInt64(&calls, 1)
+			return map[string]interface{}{"call": n, "service": "dynamic", "build": map[string]interface{}{"sha": "abc"},
+				"rt": map[string]interface{}{"go routines!": 3}}
+		}),
+		WithPreserveOrder(true),
+		WithWorkers(1),
+	)
+	_, _ = w.Write([]byte(`{"level":"info","message":"one"}`))
+	_, _ = w.Write([]byte(`{"level":"info","message":"two","version":"2.0"}`))
+	w.Close()
+
+	sent := tr.sent()
+	if len(sent) != 2 {
+		t.Fatalf("want 2 messages, got: %v", sent)
+	}
+	for i, m := range sent {
+		if m["_environment"] != "test" || m["_service"] != "dynamic" || m["_build.sha"] != "abc" ||
+			m["_call"] != float64(i+1) || m["_deploy.gitsha"] != "def" || m["_rt.goroutines"] != float64(3) {
+			t.Errorf("unexpected message: %v", m)
+		}
+	}
+	if sent[0]["_version"] != "1.0" || sent[1]["_version"] != "2.0" {
+		t.Errorf("event fields must take precedence: %v", sent)
+	}
+}
@@ -0,0 +1,217 @@
+package zgelf
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const defaultQueueSize = 500
+
+type OverflowPolicy string
+
+const (
+	// OverflowBlock blocks the caller, until there is space in the queue.
+	OverflowBlock = OverflowPolicy("block")
+	// OverflowDropNewest discards the event, which does not fit into the queue.
+	OverflowDropNewest = OverflowPolicy("drop_newest")
+	// OverflowDropOldest discards the oldest event in the queue.
+	OverflowDropOldest = OverflowPolicy("drop_oldest")
+	// OverflowSpill writes the event, which does not fit into the queue,
+	// to the temporary logs in the background. It is dropped, if no
+	// temporary log path is set or the writing falls behind.
+	OverflowSpill = OverflowPolicy("spill")
+)
+
+func validateOverflowPolicy(policy OverflowPolicy) error {
+	switch policy {
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest, OverflowSpill:
+		return nil
+	default:
+		return fmt.Errorf("unknown overflow policy: %s", policy)
+	}
+}
+
+// eventQueue is a bounded queue for the decoded events, the size
+// and the policy can be changed at any time.
+type eventQueue struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	idle     *sync.Cond
+	events   []map[string]interface{}
+	size     int
+	policy   OverflowPolicy
+	busy     int
+	seq      uint64
+	retire   int
+	closed   bool
+}
+
+func newEventQueue(size int) *eventQueue {
+	q := eventQueue{
+		events: make([]map[string]interface{}, 0, size),
+		size:   size,
+		policy: OverflowBlock,
+	}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	q.idle = sync.NewCond(&q.mu)
+	return &q
+}
+
+func (q *eventQueue) setSize(size int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.size = size
+	q.notFull.Broadcast()
+}
+
+func (q *eventQueue) setPolicy(policy OverflowPolicy) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.policy = policy
+	q.notFull.Broadcast()
+}
+
+// push adds the event to the queue, a full queue is handled according to
+// the policy. It returns the event which was not queued, either the new or
+// the oldest one, and the policy applied. The result is nil, if there was
+// space in the queue.
+func (q *eventQueue) push(evt map[string]interface{}) (map[string]interface{}, OverflowPolicy) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.policy == OverflowBlock && len(q.events) >= q.size && !q.closed {
+		q.notFull.Wait()
+	}
+	if q.closed {
+		return evt, q.policy
+	}
+
+	var rejected map[string]interface{}
+	if len(q.events) >= q.size {
+		switch q.policy {
+		case OverflowDropOldest:
+			rejected = q.events[0]
+			q.events[0] = nil
+			q.events = q.events[1:]
+		default:
+			return evt, q.policy
+		}
+	}
+
+	q.events = append(q.events, evt)
+	q.notEmpty.Signal()
+	return rejected, q.policy
+}
+
+// pop returns the next event and its sequence number, it blocks until
+// there is one. It returns false, if the queue is closed and empty or the
+// calling routine has to retire. Every event returned has to be marked
+// as done, when it is processed.
+func (q *eventQueue) pop() (map[string]interface{}, uint64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.events) == 0 && !q.closed && q.retire == 0 {
+		q.notEmpty.Wait()
+	}
+	if q.retire > 0 {
+		q.retire--
+		return nil, 0, false
+	}
+	if len(q.events) == 0 {
+		return nil, 0, false
+	}
+
+	evt := q.events[0]
+	q.events[0] = nil
+	q.events = q.events[1:]
+	seq := q.seq
+	q.seq++
+	q.busy++
+	q.notFull.Signal()
+	return evt, seq, true
+}
+
+// release lets `n` routines waiting in pop retire.
+func (q *eventQueue) release(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.retire += n
+	q.notEmpty.Broadcast()
+}
+
+// done marks an event returned by pop as processed.
+func (q *eventQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.busy--
+	if q.busy == 0 && len(q.events) == 0 {
+		q.idle.Broadcast()
+	}
+}
+
+// wait blocks until the queue is empty and all events are processed
+// or the context is done.
+func (q *eventQueue) wait(ctx context.Context) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.idle.Broadcast()
+			q.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.busy > 0 || len(q.events) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		q.idle.Wait()
+	}
+	return nil
+}
+
+// drain removes and returns all queued events.
+func (q *eventQueue) drain() []map[string]interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	events := q.events
+	q.events = make([]map[string]interface{}, 0)
+	if q.busy == 0 {
+		q.idle.Broadcast()
+	}
+	q.notFull.Broadcast()
+	return events
+}
+
+// close wakes up all waiting routines, events already
+// queued can still be taken from the queue.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+func (q *eventQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.events)
+}
@@ -0,0 +1,154 @@
+package zgelf
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func event(msg string) map[string]interface{} {
+	return map[string]interface{}{"message": msg}
+}
+
+func Test_eventQueue_push(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       OverflowPolicy
+		wantRejected string
+		wantQueued   []string
+	}{
+		{"drop newest", OverflowDropNewest, "3", []string{"1", "2"}},
+		{"drop oldest", OverflowDropOldest, "1", []string{"2", "3"}},
+		{"spill", OverflowSpill, "3", []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newEventQueue(2)
+			q.setPolicy(tt.policy)
+			for _, m := range []string{"1", "2"} {
+				if r, _ := q.push(event(m)); r != nil {
+					t.Fatalf("push() rejected event %v", r)
+				}
+			}
+
+			r, policy := q.push(event("3"))
+			if r == nil || r["message"] != tt.wantRejected {
+				t.Errorf("push() rejected = %v, want %s", r, tt.wantRejected)
+			}
+			if policy != tt.policy {
+				t.Errorf("push() policy = %s, want %s", policy, tt.policy)
+			}
+
+			q.close()
+			var got []string
+			for {
+				evt, _, ok := q.pop()
+				if !ok {
+					break
+				}
+				got = append(got, evt["message"].(string))
+				q.done()
+			}
+			if len(got) != len(tt.wantQueued) || got[0] != tt.wantQueued[0] || got[1] != tt.wantQueued[1] {
+				t.Errorf("queued events = %v, want %v", got, tt.wantQueued)
+			}
+		})
+	}
+}
+
+func Test_eventQueue_block(t *testing.T) {
+	q := newEventQueue(1)
+	q.push(event("1"))
+
+	pushed := make(chan struct{})
+	go func() {
+		q.push(event("2"))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("push() did not block on a full queue")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	if _, _, ok := q.pop(); !ok {
+		t.Fatal("pop() returned no event")
+	}
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push() still blocked after pop")
+	}
+	if q.len() != 1 {
+		t.Errorf("len() = %d, want 1", q.len())
+	}
+}
+
+func Test_eventQueue_wait(t *testing.T) {
+	q := newEventQueue(10)
+	q.push(event("1"))
+
+	idle := make(chan struct{})
+	go func() {
+		_ = q.wait(context.Background())
+		close(idle)
+	}()
+
+	_, _, _ = q.pop()
+	select {
+	case <-idle:
+		t.Fatal("wait() returned while an event is processed")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	q.done()
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Fatal("wait() did not return after the event was processed")
+	}
+}
+
+func Test_eventQueue_release(t *testing.T) {
+	q := newEventQueue(10)
+
+	retired := make(chan bool)
+	go func() {
+		_, _, ok := q.pop()
+		retired <- !ok
+	}()
+
+	q.release(1)
+	select {
+	case ok := <-retired:
+		if !ok {
+			t.Error("pop() returned an event instead of retiring")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pop() did not return after release")
+	}
+
+	q.push(event("1"))
+	if _, seq, ok := q.pop(); !ok || seq != 0 {
+		t.Errorf("pop() = %d, %v, want 0, true", seq, ok)
+	}
+}
+
+func Test_eventQueue_waitContext(t *testing.T) {
+	q := newEventQueue(10)
+	q.push(event("1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := q.wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if events := q.drain(); len(events) != 1 {
+		t.Errorf("drain() = %v, want 1 event", events)
+	}
+	if err := q.wait(context.Background()); err != nil {
+		t.Errorf("wait() after drain error = %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package zgelf
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RedactionRule replaces the matches of a pattern in the string values
+// of the short and full message and the additional fields.
+type RedactionRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+	// Check optionally verifies a match, before it is replaced.
+	Check func(match string) bool
+}
+
+var (
+	// RedactEmail replaces email addresses.
+	RedactEmail = RedactionRule{
+		Pattern:     regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+		Replacement: "[REDACTED]",
+	}
+	// RedactCreditCard replaces credit card numbers, which are
+	// valid according to the Luhn algorithm.
+	RedactCreditCard = RedactionRule{
+		Pattern:     regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+		Replacement: "[REDACTED]",
+		Check:       luhn,
+	}
+	// RedactBearerToken replaces bearer tokens, e.g. of an authorization header.
+	RedactBearerToken = RedactionRule{
+		Pattern:     regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`),
+		Replacement: "Bearer [REDACTED]",
+	}
+)
+
+func (r RedactionRule) apply(s string) string {
+	if r.Check == nil {
+		return r.Pattern.ReplaceAllString(s, r.Replacement)
+	}
+	return r.Pattern.ReplaceAllStringFunc(s, func(m string) string {
+		if r.Check(m) {
+			return r.Replacement
+		}
+		return m
+	})
+}
+
+// luhn reports if the digits of `s` have a valid Luhn checksum.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+// validateFieldPatterns checks the patterns of a deny or allow list.
+func validateFieldPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid field pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// matchField reports if the additional field `key` matches one of the
+// patterns, they are compared with and without the leading underscore.
+func matchField(patterns []string, key string) bool {
+	name := strings.TrimPrefix(key, "_")
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// protect applies the deny and allow lists to the additional fields of the
+// message and the redaction rules to the messages and additional fields.
+func (w *GelfWriter) protect(evn map[string]interface{}) {
+	for k, v := range evn {
+		if !strings.HasPrefix(k, "_") {
+			if k != ShortMessageFieldName && k != FullMessageFieldName {
+				continue
+			}
+		} else if matchField(w.opts.denyFields, k) ||
+			len(w.opts.allowFields) > 0 && !matchField(w.opts.allowFields, k) {
+			delete(evn, k)
+			continue
+		}
+
+		if len(w.opts.redactionRules) > 0 {
+			if r, ok := w.redact(v); ok {
+				evn[k] = r
+			}
+		}
+	}
+}
+
+// redact applies the redaction rules to a string or number, it
+// returns the redacted string and true, if the value was changed.
+func (w *GelfWriter) redact(v interface{}) (string, bool) {
+	var s string
+	switch t := v.(type) {
+	case string:
+		s = t
+	case json.Number:
+		s = t.String()
+	default:
+		return "", false
+	}
+
+	r := s
+	for _, rule := range w.opts.redactionRules {
+		r = rule.apply(r)
+	}
+	return r, r != s
+}
@@ -0,0 +1,399 @@
+package zgelf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var tempLogFile = regexp.MustCompile(tempLogFileRegex)
+
+var ErrorSpoolFull = errors.New("temporary log limit exceeded")
+
+type SpoolPolicy string
+
+const (
+	// SpoolDropOldest removes the oldest files, until the limits are met.
+	SpoolDropOldest = SpoolPolicy("drop_oldest")
+	// SpoolRefuseNew discards a new file, if it would exceed the limits.
+	SpoolRefuseNew = SpoolPolicy("refuse_new")
+)
+
+// SpoolLimits restricts the temporary logs kept on disk, a zero value
+// means no limit. Files older than MaxAge are always removed.
+type SpoolLimits struct {
+	MaxBytes int64
+	MaxFiles int
+	MaxAge   time.Duration
+	Policy   SpoolPolicy
+}
+
+// spool persists buffers, which could not be sent, as files in a directory.
+// Every file contains the messages of one buffer, separated by newlines.
+// The files are named `log_<n>.log` or `log_<n>.gz`, where `n` is increasing,
+// so they can be replayed in the order they were written.
+type spool struct {
+	dir       string
+	compress  bool
+	limits    SpoolLimits
+	mu        sync.Mutex
+	replayMu  sync.Mutex
+	next      int
+	pending   int
+	replaying int
+	evicted   uint64
+}
+
+type spoolFile struct {
+	index   int
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// newSpool creates the directory if necessary and picks up
+// the files left by a previous process.
+func newSpool(dir string) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := spool{
+		dir:       dir,
+		compress:  true,
+		replaying: -1,
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		s.next = files[len(files)-1].index + 1
+	}
+	s.pending = len(files)
+	return &s, nil
+}
+
+// pendingFiles returns the number of files waiting to be sent.
+func (s *spool) pendingFiles() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pending
+}
+
+// evictedMessages returns the number of messages removed
+// because of the limits.
+func (s *spool) evictedMessages() uint64 {
+	return atomic.LoadUint64(&s.evicted)
+}
+
+// setLimits sets the limits and applies them to the existing files.
+func (s *spool) setLimits(limits SpoolLimits) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits = limits
+	return s.enforceLimits(-1)
+}
+
+// write saves the messages of the buffer to a new file. If the limits are
+// exceeded and the policy is SpoolRefuseNew, the file is discarded and
+// ErrorSpoolFull is returned.
+func (s *spool) write(buffer *logBuffer) error {
+	if buffer.Size() == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.next
+	s.next++
+
+	ext := ".log"
+	if s.compress {
+		ext = ".gz"
+	}
+	if err := s.writeFile(fmt.Sprintf("log_%d%s", index, ext), buffer); err != nil {
+		return err
+	}
+	s.pending++
+	return s.enforceLimits(index)
+}
+
+// enforceLimits removes expired files and applies the policy, if the
+// limits are exceeded. The file with the index `newest` was just written
+// and is discarded by SpoolRefuseNew. The caller has to hold the lock.
+func (s *spool) enforceLimits(newest int) error {
+	if s.limits == (SpoolLimits{}) {
+		return nil
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	keep := files[:0]
+	for _, f := range files {
+		if s.limits.MaxAge > 0 && time.Since(f.modTime) > s.limits.MaxAge && f.index != s.replaying {
+			s.evict(f)
+			continue
+		}
+		size += f.size
+		keep = append(keep, f)
+	}
+	files = keep
+
+	exceeded := func() bool {
+		return (s.limits.MaxBytes > 0 && size > s.limits.MaxBytes) ||
+			(s.limits.MaxFiles > 0 && len(files) > s.limits.MaxFiles)
+	}
+	if !exceeded() {
+		return nil
+	}
+
+	if s.limits.Policy == SpoolRefuseNew {
+		if newest < 0 {
+			// existing files are kept, only new ones are refused
+			return nil
+		}
+		for _, f := range files {
+			if f.index == newest {
+				s.evict(f)
+				return ErrorSpoolFull
+			}
+		}
+		return nil
+	}
+
+	for len(files) > 0 && exceeded() {
+		f := files[0]
+		files = files[1:]
+		if f.index == s.replaying {
+			continue
+		}
+		s.evict(f)
+		size -= f.size
+	}
+	return nil
+}
+
+// evict removes the file and counts its messages as evicted.
+// The caller has to hold the lock.
+func (s *spool) evict(f spoolFile) {
+	var count int
+	if b, err := s.readFile(f.name); err == nil {
+		count = len(b.buffers)
+	}
+	if err := os.Remove(filepath.Join(s.dir, f.name)); err != nil {
+		return
+	}
+	if s.pending > 0 {
+		s.pending--
+	}
+	atomic.AddUint64(&s.evicted, uint64(count))
+}
+
+// replay sends the files in the order they were written and removes them
+// afterwards. It stops at the first error, messages already sent are
+// removed from the failed file.
+func (s *spool) replay(send func(buffer *logBuffer) error) error {
+	if s.pendingFiles() == 0 {
+		return nil
+	}
+
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	s.mu.Lock()
+	err := s.enforceLimits(-1)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	defer s.setReplaying(nil)
+	for i := range files {
+		f := files[i]
+		if !s.setReplaying(&f) {
+			// the file was evicted in the meantime
+			continue
+		}
+		buffer, err := s.readFile(f.name)
+		if err != nil {
+			// move the file aside, so it does not block the replay forever
+			_ = os.Rename(filepath.Join(s.dir, f.name), filepath.Join(s.dir, f.name+".corrupt"))
+			_ = s.remove(f.name)
+			return fmt.Errorf("cannot read temporary log %s: %w", f.name, err)
+		}
+		count := len(buffer.buffers)
+
+		if err := send(buffer); err != nil {
+			if len(buffer.buffers) < count {
+				_ = s.writeFile(f.name, buffer)
+			}
+			return err
+		}
+		if err := s.remove(f.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setReplaying marks the file as being replayed, so it is not evicted.
+// It returns false, if the file does not exist anymore.
+func (s *spool) setReplaying(f *spoolFile) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f == nil {
+		s.replaying = -1
+		return true
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, f.name)); err != nil {
+		return false
+	}
+	s.replaying = f.index
+	return true
+}
+
+func (s *spool) remove(name string) error {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending > 0 {
+		s.pending--
+	}
+	return nil
+}
+
+// files returns the spooled files ordered by their index.
+func (s *spool) files() ([]spoolFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]spoolFile, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		m := tempLogFile.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		i, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, spoolFile{
+			index:   i,
+			name:    e.Name(),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].index < files[j].index
+	})
+	return files, nil
+}
+
+// writeFile writes the buffer to a temporary file first, which is renamed
+// afterwards, so a partially written file is never replayed.
+func (s *spool) writeFile(name string, buffer *logBuffer) error {
+	tmp, err := os.CreateTemp(s.dir, ".tmp_"+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	var w io.Writer = tmp
+	var gz *gzip.Writer
+	if filepath.Ext(name) == ".gz" {
+		gz = gzip.NewWriter(tmp)
+		w = gz
+	}
+
+	bw := bufio.NewWriter(w)
+	buffer.mu.RLock()
+	for _, d := range buffer.buffers {
+		_, _ = bw.Write(d)
+		_ = bw.WriteByte('\n')
+	}
+	buffer.mu.RUnlock()
+
+	err = bw.Flush()
+	if gz != nil && err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
+
+func (s *spool) readFile(name string) (*logBuffer, error) {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if filepath.Ext(name) == ".gz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	buffer := NewLogBuffer()
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			buffer.Add(line)
+		}
+		if err == io.EOF {
+			return buffer, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package zgelf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func bufferOf(messages ...string) *logBuffer {
+	b := NewLogBuffer()
+	for _, m := range messages {
+		b.Add([]byte(m))
+	}
+	return b
+}
+
+func messagesOf(b *logBuffer) []string {
+	m := make([]string, 0, len(b.buffers))
+	for _, d := range b.buffers {
+		m = append(m, string(d))
+	}
+	return m
+}
+
+func Test_spool_replay(t *testing.T) {
+	for _, compress := range []bool{true, false} {
+		dir := t.TempDir()
+		s, err := newSpool(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.compress = compress
+
+		if err := s.write(bufferOf(`{"a":1}`, `{"a":2}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.write(bufferOf(`{"a":3}`)); err != nil {
+			t.Fatal(err)
+		}
+
+		// a new spool picks up the files of the previous one
+		s, err = newSpool(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.pendingFiles() != 2 || s.next != 2 {
+			t.Errorf("newSpool() pending = %d, next = %d, want 2, 2", s.pendingFiles(), s.next)
+		}
+
+		sendErr := errors.New("send failed")
+		err = s.replay(func(buffer *logBuffer) error {
+			return sendErr
+		})
+		if err != sendErr {
+			t.Errorf("replay() error = %v, want %v", err, sendErr)
+		}
+
+		var got []string
+		err = s.replay(func(buffer *logBuffer) error {
+			got = append(got, messagesOf(buffer)...)
+			buffer.Clear()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("replay() error = %v", err)
+		}
+		if want := []string{`{"a":1}`, `{"a":2}`, `{"a":3}`}; !reflect.DeepEqual(got, want) {
+			t.Errorf("replay() sent %v, want %v", got, want)
+		}
+
+		files, _ := s.files()
+		if len(files) != 0 || s.pendingFiles() != 0 {
+			t.Errorf("files left after replay: %v", files)
+		}
+	}
+}
+
+func Test_spool_replayPartial(t *testing.T) {
+	s, err := newSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.write(bufferOf(`{"a":1}`, `{"a":2}`, `{"a":3}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.replay(func(buffer *logBuffer) error {
+		_, _ = buffer.Pull()
+		return errors.New("send failed")
+	})
+	if err == nil {
+		t.Fatal("replay() want error")
+	}
+
+	files, _ := s.files()
+	if len(files) != 1 {
+		t.Fatalf("want 1 file, got: %v", files)
+	}
+	b, err := s.readFile(files[0].name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`{"a":2}`, `{"a":3}`}; !reflect.DeepEqual(messagesOf(b), want) {
+		t.Errorf("remaining messages %v, want %v", messagesOf(b), want)
+	}
+}
+
+func Test_spool_files(t *testing.T) {
+	dir := t.TempDir()
+	for _, n := range []string{"log_10.gz", "log_2.log", "log_1.gz", "log_3.txt", ".tmp_log_4.gz123", "other"} {
+		if err := os.WriteFile(filepath.Join(dir, n), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := newSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := s.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, f.name)
+	}
+	want := []string{"log_1.gz", "log_2.log", "log_10.gz"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files() = %v, want %v", got, want)
+	}
+	if s.next != 11 {
+		t.Errorf("next index = %d, want 11", s.next)
+	}
+}
+
+func Test_spool_limits(t *testing.T) {
+	tests := []struct {
+		name        string
+		limits      SpoolLimits
+		wantErr     error
+		wantFiles   []string
+		wantEvicted uint64
+	}{
+		{"no limits", SpoolLimits{}, nil,
+			[]string{"log_0.log", "log_1.log", "log_2.log", "log_3.log"}, 0},
+		{"max files drop oldest", SpoolLimits{MaxFiles: 2, Policy: SpoolDropOldest}, nil,
+			[]string{"log_2.log", "log_3.log"}, 4},
+		{"max files refuse new", SpoolLimits{MaxFiles: 2, Policy: SpoolRefuseNew}, ErrorSpoolFull,
+			[]string{"log_0.log", "log_1.log"}, 3},
+		{"max bytes drop oldest", SpoolLimits{MaxBytes: 35, Policy: SpoolDropOldest}, nil,
+			[]string{"log_2.log", "log_3.log"}, 4},
+		{"max bytes refuse new", SpoolLimits{MaxBytes: 35, Policy: SpoolRefuseNew}, ErrorSpoolFull,
+			[]string{"log_0.log", "log_1.log"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newSpool(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.compress = false
+			if err := s.setLimits(tt.limits); err != nil {
+				t.Fatal(err)
+			}
+
+			// every file has a size of 16 bytes
+			var lastErr error
+			for _, b := range []*logBuffer{
+				bufferOf(`{"a":1}`, `{"a":2}`),
+				bufferOf(`{"a":3}`, `{"a":4}`),
+				bufferOf(`{"a":5}`, `{"a":6}`),
+				bufferOf(`{"a":7}`),
+			} {
+				if err := s.write(b); err != nil {
+					lastErr = err
+				}
+			}
+			if lastErr != tt.wantErr {
+				t.Errorf("write() error = %v, want %v", lastErr, tt.wantErr)
+			}
+
+			files, _ := s.files()
+			var got []string
+			for _, f := range files {
+				got = append(got, f.name)
+			}
+			if !reflect.DeepEqual(got, tt.wantFiles) {
+				t.Errorf("files = %v, want %v", got, tt.wantFiles)
+			}
+			if s.evictedMessages() != tt.wantEvicted {
+				t.Errorf("evictedMessages() = %d, want %d", s.evictedMessages(), tt.wantEvicted)
+			}
+			if s.pendingFiles() != len(tt.wantFiles) {
+				t.Errorf("pendingFiles() = %d, want %d", s.pendingFiles(), len(tt.wantFiles))
+			}
+		})
+	}
+}
+
+func Test_spool_maxAge(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.write(bufferOf(`{"a":1}`, `{"a":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.write(bufferOf(`{"a":3}`)); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := s.files()
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, files[0].name), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// the limits are applied to the files of a previous process
+	s, err = newSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setLimits(SpoolLimits{MaxAge: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	files, _ = s.files()
+	if len(files) != 1 || files[0].index != 1 {
+		t.Errorf("unexpected files left: %v", files)
+	}
+	if s.evictedMessages() != 2 {
+		t.Errorf("evictedMessages() = %d, want 2", s.evictedMessages())
+	}
+}
@@ -1,40 +1,144 @@
 package zgelf
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
-type httpTransport struct {
+const defaultHttpPath = "/gelf"
+
+type HttpTransport struct {
 	url        string
-	port       uint16
 	bufferSize int
 	duration   time.Duration
 	client     *http.Client
 }
 
-func NewHttpTransport(url string, port uint16) (*httpTransport, error) {
+// NewHttpTransport creates a transport which posts the GELF messages to the
+// HTTP input of a Graylog server. The parameter `serverUrl` is the address of
+// the server, e.g. `https://graylog.example.org`, if `port` is not zero it
+// replaces the port of the url. The path defaults to `/gelf`.
+// The proxy is taken from the environment (HTTP_PROXY, HTTPS_PROXY).
+func NewHttpTransport(serverUrl string, port uint16) (*HttpTransport, error) {
+	u, err := url.Parse(serverUrl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing host in url: %s", serverUrl)
+	}
+	if port > 0 {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(int(port)))
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = defaultHttpPath
+	}
+
+	t := HttpTransport{
+		url:        u.String(),
+		bufferSize: 64 * 1024,
+		duration:   time.Second * 30,
+		client: &http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+	return &t, nil
+}
 
-	return nil, errors.New("not implemented")
+// SetBufferSize sets the size in bytes, after which the buffer is sent.
+func (t *HttpTransport) SetBufferSize(size int) {
+	t.bufferSize = size
+}
 
-	//t := httpTransport{
-	//	url:        url,
-	//	port:       port,
-	//	bufferSize: 64 * 1024,
-	//	duration:   time.Second * 30,
-	//}
-	//return &t
+// SetBufferTime sets the time after which the buffer is sent,
+// regardless of its size.
+func (t *HttpTransport) SetBufferTime(d time.Duration) {
+	t.duration = d
 }
 
-func (t *httpTransport) Mode() TransportMode {
+// SetTimeout sets the timeout of a request.
+func (t *HttpTransport) SetTimeout(timeout time.Duration) {
+	t.client.Timeout = timeout
+}
+
+func (t *HttpTransport) Mode() TransportMode {
 	return TransportHttp
 }
 
-func (t *httpTransport) BufferSize() int {
+func (t *HttpTransport) BufferSize() int {
 	return t.bufferSize
 }
-func (t *httpTransport) SendBuffer(buffer *logBuffer) error {
 
-	return errors.New("not implemented")
+func (t *HttpTransport) BufferTime() time.Duration {
+	return t.duration
+}
+
+// SendBuffer posts every message of the buffer to the server. A message is
+// removed from the buffer after it was accepted, so in case of an error the
+// buffer contains all messages which are not sent. Messages the server
+// refuses permanently are removed as well and reported in a *SendError.
+func (t *HttpTransport) SendBuffer(buffer *logBuffer) error {
+	sendErr := &SendError{}
+	for buffer.Size() > 0 {
+		d, err := buffer.Peek()
+		if err != nil {
+			return err
+		}
+		if err := t.post(d); err != nil {
+			if !errors.Is(err, ErrorMessageTooLarge) && !errors.Is(err, ErrorMessageRejected) {
+				if len(sendErr.Errors) == 0 {
+					return err
+				}
+				// the remaining messages stay in the buffer
+				sendErr.Errors = append(sendErr.Errors, err)
+				return sendErr
+			}
+			sendErr.add(d, err)
+		}
+		_, _ = buffer.Pull()
+	}
+
+	if len(sendErr.Errors) > 0 {
+		return sendErr
+	}
+	return nil
+}
+
+func (t *HttpTransport) post(data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body, so the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return nil
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w, response from %s: %s", ErrorMessageTooLarge, t.url, resp.Status)
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		// the request will not succeed, if it is sent again
+		return fmt.Errorf("%w, response from %s: %s", ErrorMessageRejected, t.url, resp.Status)
+	default:
+		return fmt.Errorf("unexpected response from %s: %s", t.url, resp.Status)
+	}
 }
@@ -0,0 +1,170 @@
+package zgelf
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewHttpTransport(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		port    uint16
+		want    string
+		wantErr bool
+	}{
+		{"default path", "http://graylog.local", 12201, "http://graylog.local:12201/gelf", false},
+		{"https", "https://graylog.local", 0, "https://graylog.local/gelf", false},
+		{"custom path", "https://graylog.local:8443/input", 0, "https://graylog.local:8443/input", false},
+		{"replace port", "https://graylog.local:8443", 443, "https://graylog.local:443/gelf", false},
+		{"invalid scheme", "udp://graylog.local", 12201, "", true},
+		{"missing host", "http://", 12201, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewHttpTransport(tt.url, tt.port)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewHttpTransport() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.url != tt.want {
+				t.Errorf("NewHttpTransport() url = %v, want %v", got.url, tt.want)
+			}
+		})
+	}
+}
+
+func TestHttpTransport_SendBuffer(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	status := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/gelf" || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		if status != 0 && !strings.Contains(string(b), "accepted") {
+			w.WriteHeader(status)
+			return
+		}
+		received = append(received, string(b))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	tr, err := NewHttpTransport(srv.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewLogBuffer()
+	b.Add([]byte(`{"short_message":"one"}`))
+	b.Add([]byte(`{"short_message":"two"}`))
+	if err := tr.SendBuffer(b); err != nil {
+		t.Fatalf("SendBuffer() error = %v", err)
+	}
+	if b.Size() != 0 {
+		t.Errorf("buffer not empty after send, size: %d", b.Size())
+	}
+	if len(received) != 2 || received[0] != `{"short_message":"one"}` {
+		t.Errorf("unexpected messages received: %v", received)
+	}
+
+	mu.Lock()
+	status = http.StatusServiceUnavailable
+	mu.Unlock()
+	b.Add([]byte(`{"short_message":"three"}`))
+	if err := tr.SendBuffer(b); err == nil {
+		t.Errorf("SendBuffer() want error on bad response")
+	}
+	if b.Size() == 0 {
+		t.Errorf("unsent message was removed from buffer")
+	}
+	b.Clear()
+
+	// messages the server refuses are rejected, the others are still sent
+	for _, code := range []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge} {
+		mu.Lock()
+		status = code
+		received = nil
+		mu.Unlock()
+		b.Add([]byte(`{"short_message":"refused"}`))
+		b.Add([]byte(`{"short_message":"accepted"}`))
+		err := tr.SendBuffer(b)
+		var sendErr *SendError
+		if !errors.As(err, &sendErr) {
+			t.Fatalf("SendBuffer() error = %v, want *SendError", err)
+		}
+		if len(sendErr.Rejected) != 1 || len(sendErr.Failed) != 0 {
+			t.Errorf("status %d: rejected = %d, failed = %d, want 1, 0",
+				code, len(sendErr.Rejected), len(sendErr.Failed))
+		}
+		if b.Size() != 0 || len(received) != 1 {
+			t.Errorf("status %d: buffer size = %d, received = %v", code, b.Size(), received)
+		}
+	}
+	if !errors.Is(tr.post([]byte(`{}`)), ErrorMessageTooLarge) {
+		t.Errorf("post() want ErrorMessageTooLarge for status 413")
+	}
+}
+
+func TestHttpTransport_replayRejected(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		b, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(b), "refused") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, string(b))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	// a refused message must not block the following temporary logs
+	dir := t.TempDir()
+	s, err := newSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range [][]string{{"refused", "one"}, {"two"}} {
+		b := NewLogBuffer()
+		for _, msg := range m {
+			b.Add([]byte(`{"short_message":"` + msg + `"}`))
+		}
+		if err := s.write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tr, err := NewHttpTransport(srv.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWriter(t, tr, WithSpoolDir(dir))
+	w.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 {
+		t.Errorf("unexpected messages received: %v", received)
+	}
+	if files, _ := s.files(); len(files) != 0 {
+		t.Errorf("temporary logs left: %v", files)
+	}
+	if w.DroppedMessages() != 1 {
+		t.Errorf("DroppedMessages() = %d, want 1", w.DroppedMessages())
+	}
+}
@@ -0,0 +1,178 @@
+package zgelf
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// tcpDelimiter terminates every GELF message sent over tcp
+var tcpDelimiter = []byte{0}
+
+type TcpTransport struct {
+	addr       string
+	bufferSize int
+	duration   time.Duration
+	timeout    time.Duration
+	tlsConfig  *tls.Config
+	conn       net.Conn
+	mu         sync.Mutex
+}
+
+// NewTcpTransport creates a transport which keeps a persistent tcp connection
+// to the server given by `conn` (host:port). The messages are delimited by a
+// null byte, as required by the GELF tcp input. If the connection breaks, it
+// is established again with the next send.
+func NewTcpTransport(conn string) (*TcpTransport, error) {
+	if _, err := net.ResolveTCPAddr("tcp", conn); err != nil {
+		return nil, err
+	}
+
+	t := TcpTransport{
+		addr:       conn,
+		bufferSize: 64 * 1024,
+		duration:   time.Second * 10,
+		timeout:    time.Second * 10,
+	}
+	return &t, nil
+}
+
+// SetBufferSize sets the size in bytes, after which the buffer is sent.
+func (t *TcpTransport) SetBufferSize(size int) {
+	t.bufferSize = size
+}
+
+// SetBufferTime sets the time after which the buffer is sent,
+// regardless of its size.
+func (t *TcpTransport) SetBufferTime(d time.Duration) {
+	t.duration = d
+}
+
+// SetTimeout sets the timeout for connecting and writing.
+func (t *TcpTransport) SetTimeout(timeout time.Duration) {
+	t.timeout = timeout
+}
+
+func (t *TcpTransport) Mode() TransportMode {
+	return TransportTcp
+}
+
+func (t *TcpTransport) BufferSize() int {
+	return t.bufferSize
+}
+
+func (t *TcpTransport) BufferTime() time.Duration {
+	return t.duration
+}
+
+// SendBuffer writes every message of the buffer to the connection. A message
+// is removed from the buffer after it was written, so in case of an error the
+// buffer contains all messages which are not sent.
+func (t *TcpTransport) SendBuffer(buffer *logBuffer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn != nil && !t.isAlive() {
+		t.close()
+	}
+
+	for buffer.Size() > 0 {
+		d, err := buffer.Peek()
+		if err != nil {
+			return err
+		}
+		if err := t.write(d); err != nil {
+			return err
+		}
+		_, _ = buffer.Pull()
+	}
+	return nil
+}
+
+// Close closes the connection to the server.
+func (t *TcpTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.close()
+	return nil
+}
+
+// write sends a single message, if writing to an already established
+// connection fails, the connection is established again once.
+func (t *TcpTransport) write(d []byte) error {
+	retry := t.conn != nil
+	for {
+		if err := t.connect(); err != nil {
+			return err
+		}
+
+		_ = t.conn.SetWriteDeadline(time.Now().Add(t.timeout))
+		b := net.Buffers{d, tcpDelimiter}
+		_, err := b.WriteTo(t.conn)
+		if err == nil {
+			return nil
+		}
+
+		t.close()
+		if !retry {
+			return err
+		}
+		retry = false
+	}
+}
+
+func (t *TcpTransport) connect() error {
+	if t.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", t.addr, t.timeout)
+	if err != nil {
+		return err
+	}
+
+	if t.tlsConfig != nil {
+		c := t.tlsConfig
+		if c.ServerName == "" {
+			// the server name is required for the verification
+			c = c.Clone()
+			c.ServerName, _, _ = net.SplitHostPort(t.addr)
+		}
+		tc := tls.Client(conn, c)
+		_ = tc.SetDeadline(time.Now().Add(t.timeout))
+		if err := tc.Handshake(); err != nil {
+			_ = conn.Close()
+			return fmt.Errorf("tls handshake with %s failed: %w", t.addr, err)
+		}
+		_ = tc.SetDeadline(time.Time{})
+		conn = tc
+	}
+
+	t.conn = conn
+	return nil
+}
+
+func (t *TcpTransport) close() {
+	if t.conn != nil {
+		_ = t.conn.Close()
+		t.conn = nil
+	}
+}
+
+// isAlive checks, if the server closed the connection in the meantime.
+// Graylog never sends data over a GELF connection, so a read either times out
+// on a healthy connection or returns an error on a closed one. A deadline in
+// the past would not even try to read, hence the short wait.
+func (t *TcpTransport) isAlive() bool {
+	_ = t.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer func() {
+		_ = t.conn.SetReadDeadline(time.Time{})
+	}()
+
+	_, err := t.conn.Read(make([]byte, 1))
+	return err == nil || errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,112 @@
+package zgelf
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpServer accepts connections and forwards all null-byte delimited
+// messages to the returned channel.
+func tcpServer(t *testing.T) (net.Listener, chan string, chan net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan string, 10)
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+			go func(c net.Conn) {
+				r := bufio.NewReader(c)
+				for {
+					m, err := r.ReadString(0)
+					if err != nil {
+						return
+					}
+					messages <- m[:len(m)-1]
+				}
+			}(c)
+		}
+	}()
+	return l, messages, conns
+}
+
+func receive(t *testing.T, messages chan string) string {
+	select {
+	case m := <-messages:
+		return m
+	case <-time.After(time.Second):
+		t.Helper()
+		t.Fatal("timeout waiting for message")
+	}
+	return ""
+}
+
+func TestTcpTransport_SendBuffer(t *testing.T) {
+	l, messages, conns := tcpServer(t)
+	defer l.Close()
+
+	tr, err := NewTcpTransport(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	b := NewLogBuffer()
+	b.Add([]byte(`{"short_message":"one"}`))
+	b.Add([]byte(`{"short_message":"two"}`))
+	if err := tr.SendBuffer(b); err != nil {
+		t.Fatalf("SendBuffer() error = %v", err)
+	}
+	if b.Size() != 0 {
+		t.Errorf("buffer not empty after send, size: %d", b.Size())
+	}
+	if m := receive(t, messages); m != `{"short_message":"one"}` {
+		t.Errorf("unexpected message: %s", m)
+	}
+	if m := receive(t, messages); m != `{"short_message":"two"}` {
+		t.Errorf("unexpected message: %s", m)
+	}
+
+	// the server closes the connection, the next send has to reconnect
+	(<-conns).Close()
+	time.Sleep(time.Millisecond * 50)
+
+	b.Add([]byte(`{"short_message":"three"}`))
+	if err := tr.SendBuffer(b); err != nil {
+		t.Fatalf("SendBuffer() after close error = %v", err)
+	}
+	if m := receive(t, messages); m != `{"short_message":"three"}` {
+		t.Errorf("unexpected message: %s", m)
+	}
+}
+
+func TestTcpTransport_SendBufferUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	tr, err := NewTcpTransport(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewLogBuffer()
+	b.Add([]byte(`{"short_message":"one"}`))
+	if err := tr.SendBuffer(b); err == nil {
+		t.Errorf("SendBuffer() want error for unreachable server")
+	}
+	if b.Size() == 0 {
+		t.Errorf("unsent message was removed from buffer")
+	}
+}
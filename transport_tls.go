@@ -0,0 +1,79 @@
+package zgelf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TlsConfig configures the TLS connection of a tcp transport.
+type TlsConfig struct {
+	// CAFile is a PEM file with the certificates used to verify the server,
+	// the system pool is used if empty.
+	CAFile string
+	// CertFile and KeyFile are the PEM encoded client certificate and key,
+	// used for mutual TLS. Both or none have to be set.
+	CertFile string
+	KeyFile  string
+	// ServerName is used to verify the server certificate, it defaults to
+	// the host of the connection.
+	ServerName string
+	// MinVersion is the minimum accepted TLS version, defaults to TLS 1.2.
+	MinVersion uint16
+	// InsecureSkipVerify disables the verification of the server
+	// certificate, never use this in production.
+	InsecureSkipVerify bool
+}
+
+// NewTlsTransport creates a tcp transport, which connects to the server
+// given by `conn` (host:port) over TLS. Invalid certificate files are
+// reported here, errors during the handshake are returned by SendBuffer.
+func NewTlsTransport(conn string, config TlsConfig) (*TcpTransport, error) {
+	c, err := config.build()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := NewTcpTransport(conn)
+	if err != nil {
+		return nil, err
+	}
+	t.tlsConfig = c
+	return t, nil
+}
+
+func (c TlsConfig) build() (*tls.Config, error) {
+	tc := tls.Config{
+		ServerName:         c.ServerName,
+		MinVersion:         c.MinVersion,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if tc.MinVersion == 0 {
+		tc.MinVersion = tls.VersionTLS12
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate in CA file: %s", c.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key are both required")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return &tc, nil
+}
@@ -0,0 +1,184 @@
+package zgelf
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) writePem(t *testing.T, dir, name string) (certFile, keyFile string) {
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+".key")
+	b, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestTlsTransport_SendBuffer(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil, true)
+	server := newTestCert(t, "localhost", ca, false)
+	client := newTestCert(t, "client", ca, false)
+	other := newTestCert(t, "other", nil, true)
+
+	caFile, _ := ca.writePem(t, dir, "ca")
+	clientCert, clientKey := client.writePem(t, dir, "client")
+	otherFile, _ := other.writePem(t, dir, "other")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCertificate()},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					m, err := r.ReadString(0)
+					if err != nil {
+						return
+					}
+					messages <- m[:len(m)-1]
+				}
+			}(c)
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		config  TlsConfig
+		wantErr bool
+	}{
+		{"mutual tls", TlsConfig{CAFile: caFile, CertFile: clientCert, KeyFile: clientKey}, false},
+		{"unknown authority", TlsConfig{CAFile: otherFile, CertFile: clientCert, KeyFile: clientKey}, true},
+		{"wrong server name", TlsConfig{CAFile: caFile, CertFile: clientCert, KeyFile: clientKey, ServerName: "graylog"}, true},
+		{"insecure", TlsConfig{CAFile: otherFile, CertFile: clientCert, KeyFile: clientKey, InsecureSkipVerify: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := NewTlsTransport(l.Addr().String(), tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tr.Close()
+
+			b := NewLogBuffer()
+			b.Add([]byte(`{"short_message":"tls"}`))
+			err = tr.SendBuffer(b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendBuffer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if m := receive(t, messages); m != `{"short_message":"tls"}` {
+					t.Errorf("unexpected message: %s", m)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTlsTransport(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil, true)
+	caFile, keyFile := ca.writePem(t, dir, "ca")
+
+	tests := []struct {
+		name    string
+		config  TlsConfig
+		wantErr bool
+	}{
+		{"system pool", TlsConfig{}, false},
+		{"ca file", TlsConfig{CAFile: caFile, MinVersion: tls.VersionTLS13}, false},
+		{"missing ca file", TlsConfig{CAFile: filepath.Join(dir, "missing.pem")}, true},
+		{"invalid ca file", TlsConfig{CAFile: keyFile}, true},
+		{"missing key", TlsConfig{CertFile: caFile}, true},
+		{"invalid key pair", TlsConfig{CertFile: caFile, KeyFile: caFile}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := NewTlsTransport("127.0.0.1:12201", tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewTlsTransport() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && tr.tlsConfig.MinVersion < tls.VersionTLS12 {
+				t.Errorf("NewTlsTransport() min version = %x", tr.tlsConfig.MinVersion)
+			}
+		})
+	}
+}
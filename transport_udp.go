@@ -1,20 +1,36 @@
 package zgelf
 
 import (
+	"encoding/binary"
 	"fmt"
-	"math/rand"
+	"hash/fnv"
 	"net"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
-const chunkSize = 1420
+const (
+	// ChunkSizeWAN is the chunk size recommended for networks with
+	// unknown MTU, e.g. when sending over the internet.
+	ChunkSizeWAN = 1420
+	// ChunkSizeLAN is the chunk size recommended for local networks.
+	ChunkSizeLAN = 8154
+)
+
+const chunkSize = ChunkSizeWAN
 const chunkHeader = 12
-const maxDataSize = chunkSize - chunkHeader // the maximum datagram size per chunk, should be less than the MTU
+const maxChunkSize = 65507 // the maximum payload of an udp datagram
+const maxChunks = 128
 
 type UdpTransport struct {
-	serverAddr *net.UDPAddr
-	localAddr  *net.UDPAddr
-	compress   bool
+	serverAddr  *net.UDPAddr
+	localAddr   *net.UDPAddr
+	compression Compression
+	level       int
+	chunkSize   int
+	hostHash    uint16
+	counter     uint32
 }
 
 func NewUdpTransport(conn string) (*UdpTransport, error) {
@@ -30,12 +46,40 @@ func NewUdpTransport(conn string) (*UdpTransport, error) {
 	}
 
 	t := UdpTransport{
-		serverAddr: srvAddr,
-		localAddr:  locAddr,
+		serverAddr:  srvAddr,
+		localAddr:   locAddr,
+		compression: CompressionNone,
+		chunkSize:   chunkSize,
+		hostHash:    hostHash(),
 	}
 	return &t, nil
 }
 
+// SetCompression sets the compression of the datagrams, the `level`
+// is one of the levels defined in compress/flate and is ignored if
+// the compression is `CompressionNone`. Messages are compressed
+// before they are chunked.
+func (t *UdpTransport) SetCompression(compression Compression, level int) error {
+	if err := validateCompression(compression, level); err != nil {
+		return err
+	}
+	t.compression = compression
+	t.level = level
+	return nil
+}
+
+// SetChunkSize sets the maximum size of a datagram including the chunk
+// header, larger messages are split into chunks. The size should not
+// exceed the MTU of the network, e.g. ChunkSizeWAN (the default) or
+// ChunkSizeLAN.
+func (t *UdpTransport) SetChunkSize(size int) error {
+	if size <= chunkHeader || size > maxChunkSize {
+		return fmt.Errorf("invalid chunk size: %d", size)
+	}
+	t.chunkSize = size
+	return nil
+}
+
 func (t *UdpTransport) Mode() TransportMode {
 	return TransportUdp
 }
@@ -57,47 +101,87 @@ func (t *UdpTransport) SendBuffer(buffer *logBuffer) error {
 	}
 	defer conn.Close()
 
+	c, err := newCompressor(t.compression, t.level)
+	if err != nil {
+		return err
+	}
+
+	maxData := t.chunkSize - chunkHeader
+	sendErr := &SendError{}
 	for buffer.Size() > 0 {
 		d, err := buffer.Pull()
 		if err != nil {
 			return err
 		}
-		if len(d) <= maxDataSize {
-			if _, err := conn.Write(d); err != nil {
-				return err
-			}
-		} else {
-			chunks := (len(d) / maxDataSize) + 1
-			if chunks > 128 {
-				return fmt.Errorf("buffer to big, exceeding maximum of 128 chunks: %d", chunks)
-			}
-
-			header := make([]byte, chunkHeader)
-			rand.Read(header)
-			header[0] = 0x1e
-			header[1] = 0x0f
-			header[11] = byte(chunks)
-
-			for i := byte(0); i < byte(chunks); i++ {
-				cData := make([]byte, chunkHeader)
-				header[10] = i
-				copy(cData, header)
-				o := int(i) * maxDataSize
-				r := len(d) - o
-
-				if r > maxDataSize {
-					cData = append(cData, d[o:o+maxDataSize]...)
-				} else if r > 0 {
-					cData = append(cData, d[o:o+r]...)
-				} else {
-					continue
-				}
-				if _, err := conn.Write(cData); err != nil {
-					fmt.Printf("error sending chunk %v", err)
-				}
-			}
+		// a message which fails does not affect the remaining ones
+		if err := t.send(conn, c, d, maxData); err != nil {
+			sendErr.add(d, err)
 		}
 	}
 
+	if len(sendErr.Errors) > 0 {
+		return sendErr
+	}
 	return nil
 }
+
+// send writes a single message, it is split into chunks if it
+// exceeds `maxData` after compression.
+func (t *UdpTransport) send(conn *net.UDPConn, c *compressor, d []byte, maxData int) error {
+	d, err := c.compress(d)
+	if err != nil {
+		return err
+	}
+	if len(d) <= maxData {
+		_, err := conn.Write(d)
+		return err
+	}
+
+	chunks := (len(d) + maxData - 1) / maxData
+	if chunks > maxChunks {
+		return fmt.Errorf("%w, exceeding maximum of %d chunks: %d", ErrorMessageTooLarge, maxChunks, chunks)
+	}
+
+	header := make([]byte, chunkHeader)
+	header[0] = 0x1e
+	header[1] = 0x0f
+	copy(header[2:10], t.messageId())
+	header[11] = byte(chunks)
+
+	for i := 0; i < chunks; i++ {
+		header[10] = byte(i)
+		o := i * maxData
+		end := o + maxData
+		if end > len(d) {
+			end = len(d)
+		}
+
+		cData := make([]byte, 0, chunkHeader+end-o)
+		cData = append(cData, header...)
+		cData = append(cData, d[o:end]...)
+		if _, err := conn.Write(cData); err != nil {
+			return &Error{Kind: ErrorKindChunk, Err: err}
+		}
+	}
+	return nil
+}
+
+// messageId returns the id of a chunked message as recommended by Graylog,
+// it consists of the time in milliseconds, a counter and a hash of the host
+// and the process, so the ids of concurrent senders do not collide.
+func (t *UdpTransport) messageId() []byte {
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint32(id[0:4], uint32(time.Now().UnixMilli()))
+	binary.BigEndian.PutUint16(id[4:6], uint16(atomic.AddUint32(&t.counter, 1)))
+	binary.BigEndian.PutUint16(id[6:8], t.hostHash)
+	return id
+}
+
+// hostHash returns a hash of the hostname and the process id.
+func hostHash() uint16 {
+	host, _ := os.Hostname()
+	h := fnv.New32a()
+	_, _ = fmt.Fprintf(h, "%s/%d", host, os.Getpid())
+	sum := h.Sum32()
+	return uint16(sum>>16) ^ uint16(sum)
+}
@@ -0,0 +1,270 @@
+package zgelf
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// udpServer returns a listener and a function, which reads the next
+// message and joins it, if it was chunked.
+func udpServer(t *testing.T) (*net.UDPConn, func() ([]byte, int)) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() ([]byte, int) {
+		t.Helper()
+		var chunks [][]byte
+		total := 1
+		for len(chunks) < total {
+			b := make([]byte, 65535)
+			_ = l.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := l.Read(b)
+			if err != nil {
+				t.Fatalf("error reading datagram: %v", err)
+			}
+			b = b[:n]
+			if len(b) < 2 || b[0] != 0x1e || b[1] != 0x0f {
+				return b, 1
+			}
+			if chunks == nil {
+				total = int(b[11])
+				chunks = make([][]byte, 0, total)
+			}
+			chunks = append(chunks, b[chunkHeader:])
+		}
+		return bytes.Join(chunks, nil), total
+	}
+	return l, read
+}
+
+func decompress(t *testing.T, d []byte) []byte {
+	t.Helper()
+	var r io.Reader
+	var err error
+	switch {
+	case len(d) > 1 && d[0] == 0x1f && d[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(d))
+	case len(d) > 0 && d[0] == 0x78:
+		r, err = zlib.NewReader(bytes.NewReader(d))
+	default:
+		return d
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestUdpTransport_SendBuffer(t *testing.T) {
+	l, read := udpServer(t)
+	defer l.Close()
+
+	maxData := ChunkSizeWAN - chunkHeader
+	random := make([]byte, maxData*2)
+	_, _ = rand.Read(random)
+	large := []byte(`{"short_message":"` + hex.EncodeToString(random) + `"}`)
+	repeated := []byte(`{"short_message":"` + string(bytes.Repeat([]byte("a"), maxData*4)) + `"}`)
+
+	tests := []struct {
+		name        string
+		compression Compression
+		data        []byte
+		wantMagic   []byte
+		wantChunked bool
+	}{
+		{"uncompressed", CompressionNone, []byte(`{"short_message":"one"}`), []byte(`{`), false},
+		{"uncompressed chunked", CompressionNone, large, []byte(`{`), true},
+		{"gzip", CompressionGzip, []byte(`{"short_message":"one"}`), []byte{0x1f, 0x8b}, false},
+		{"gzip chunked", CompressionGzip, large, []byte{0x1f, 0x8b}, true},
+		{"gzip avoids chunking", CompressionGzip, repeated, []byte{0x1f, 0x8b}, false},
+		{"zlib", CompressionZlib, []byte(`{"short_message":"one"}`), []byte{0x78}, false},
+		{"zlib chunked", CompressionZlib, large, []byte{0x78}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := NewUdpTransport(l.LocalAddr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tr.SetCompression(tt.compression, flate.DefaultCompression); err != nil {
+				t.Fatal(err)
+			}
+
+			b := NewLogBuffer()
+			b.Add(tt.data)
+			if err := tr.SendBuffer(b); err != nil {
+				t.Fatalf("SendBuffer() error = %v", err)
+			}
+
+			got, chunks := read()
+			if (chunks > 1) != tt.wantChunked {
+				t.Errorf("message sent in %d chunks, want chunked: %v", chunks, tt.wantChunked)
+			}
+			if !bytes.HasPrefix(got, tt.wantMagic) {
+				t.Errorf("unexpected payload start: %x", got[:2])
+			}
+			if d := decompress(t, got); !bytes.Equal(d, tt.data) {
+				t.Errorf("payload differs from sent data")
+			}
+		})
+	}
+}
+
+func TestUdpTransport_SetCompression(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression Compression
+		level       int
+		wantErr     bool
+	}{
+		{"none", CompressionNone, 0, false},
+		{"gzip default", CompressionGzip, flate.DefaultCompression, false},
+		{"zlib best", CompressionZlib, flate.BestCompression, false},
+		{"invalid level", CompressionGzip, 42, true},
+		{"unknown", Compression("lz4"), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := NewUdpTransport("127.0.0.1:12201")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tr.SetCompression(tt.compression, tt.level); (err != nil) != tt.wantErr {
+				t.Errorf("SetCompression() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUdpTransport_SendBuffer_tooLarge(t *testing.T) {
+	l, read := udpServer(t)
+	defer l.Close()
+
+	tr, err := NewUdpTransport(l.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.SetChunkSize(chunkHeader + 8); err != nil {
+		t.Fatal(err)
+	}
+
+	large := bytes.Repeat([]byte("a"), 8*200)
+	b := NewLogBuffer()
+	b.Add([]byte(`{"a":1}`))
+	b.Add(large)
+	b.Add([]byte(`{"b":2}`))
+
+	err = tr.SendBuffer(b)
+	sendErr, ok := err.(*SendError)
+	if !ok {
+		t.Fatalf("SendBuffer() error = %v, want *SendError", err)
+	}
+	if len(sendErr.Rejected) != 1 || !bytes.Equal(sendErr.Rejected[0], large) ||
+		len(sendErr.Failed) != 0 || !errors.Is(err, ErrorMessageTooLarge) {
+		t.Errorf("unexpected error: %+v", sendErr)
+	}
+	if b.Size() != 0 {
+		t.Errorf("buffer not empty: %d", b.Size())
+	}
+
+	for _, want := range []string{`{"a":1}`, `{"b":2}`} {
+		if got, _ := read(); string(got) != want {
+			t.Errorf("received %q, want %q", got, want)
+		}
+	}
+}
+
+func TestUdpTransport_chunks(t *testing.T) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tr, err := NewUdpTransport(l.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.SetChunkSize(chunkHeader + 10); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		size   int
+		chunks int
+	}{
+		{"single datagram", 10, 1},
+		{"divides evenly", 30, 3},
+		{"remainder", 31, 4},
+		{"maximum", 10 * maxChunks, maxChunks},
+	}
+	ids := map[string]bool{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewLogBuffer()
+			b.Add(bytes.Repeat([]byte("a"), tt.size))
+			if err := tr.SendBuffer(b); err != nil {
+				t.Fatalf("SendBuffer() error = %v", err)
+			}
+
+			for i := 0; i < tt.chunks; i++ {
+				d := make([]byte, 100)
+				_ = l.SetReadDeadline(time.Now().Add(time.Second))
+				n, err := l.Read(d)
+				if err != nil {
+					t.Fatalf("error reading chunk %d: %v", i, err)
+				}
+				if tt.chunks == 1 {
+					if n != tt.size {
+						t.Errorf("datagram size = %d, want %d", n, tt.size)
+					}
+					continue
+				}
+				if d[0] != 0x1e || d[1] != 0x0f || int(d[10]) != i || int(d[11]) != tt.chunks {
+					t.Errorf("unexpected chunk header: %x", d[:chunkHeader])
+				}
+				if i == 0 {
+					id := string(d[2:10])
+					if ids[id] {
+						t.Errorf("message id %x used twice", d[2:10])
+					}
+					ids[id] = true
+				}
+			}
+		})
+	}
+}
+
+func TestUdpTransport_messageId(t *testing.T) {
+	tr, err := NewUdpTransport("127.0.0.1:12201")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]bool{}
+	for i := 0; i < 10000; i++ {
+		id := tr.messageId()
+		if len(id) != 8 {
+			t.Fatalf("id length = %d, want 8", len(id))
+		}
+		if ids[string(id)] {
+			t.Fatalf("duplicate message id %x", id)
+		}
+		ids[string(id)] = true
+	}
+}
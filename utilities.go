@@ -4,87 +4,252 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/rs/zerolog"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
-func convertTime(i json.Number, timeFormat string) (float64, error) {
-	if timeFormat == zerolog.TimeFormatUnix {
-		return i.Float64()
-	} else if timeFormat == zerolog.TimeFormatUnixMs {
-		if f, err := i.Float64(); err != nil {
-			return 0, err
-		} else {
-			return f / 1000.0, nil
+// convertTime converts the timestamp of an event to the seconds since the
+// UNIX epoch. Numbers are interpreted according to the UNIX formats of zerolog,
+// strings are parsed with the layout `timeFormat`, falling back to RFC3339.
+func convertTime(v interface{}, timeFormat string) (float64, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return unixSeconds(t), nil
+	case json.Number:
+		return convertUnixTime(t, timeFormat)
+	case string:
+		layout := timeFormat
+		switch layout {
+		case zerolog.TimeFormatUnix, zerolog.TimeFormatUnixMs,
+			zerolog.TimeFormatUnixMicro, zerolog.TimeFormatUnixNano:
+			layout = time.RFC3339Nano
+		}
+		ts, err := time.Parse(layout, t)
+		if err != nil && layout != time.RFC3339Nano {
+			ts, err = time.Parse(time.RFC3339Nano, t)
 		}
-	} else if timeFormat == zerolog.TimeFormatUnixMicro {
-		if f, err := i.Int64(); err != nil {
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse time %q: %w", t, err)
+		}
+		return unixSeconds(ts), nil
+	default:
+		return 0, fmt.Errorf("unsupported time value: %v", v)
+	}
+}
+
+func convertUnixTime(i json.Number, timeFormat string) (float64, error) {
+	var div float64
+	switch timeFormat {
+	case zerolog.TimeFormatUnix:
+		return i.Float64()
+	case zerolog.TimeFormatUnixMs:
+		div = 1e3
+	case zerolog.TimeFormatUnixMicro:
+		div = 1e6
+	case zerolog.TimeFormatUnixNano:
+		div = 1e9
+	default:
+		return 0, fmt.Errorf("unknown timeformat")
+	}
+
+	n, err := i.Int64()
+	if err != nil {
+		f, err := i.Float64()
+		if err != nil {
 			return 0, err
-		} else {
-			return float64(f/1000) / 1000.0, nil
 		}
+		return f / div, nil
 	}
-	return 0, fmt.Errorf("unknown timeformat")
+	// split the seconds, so the fraction does not lose precision
+	d := int64(div)
+	return float64(n/d) + float64(n%d)/div, nil
 }
 
-func formatKey(k string) (string, error) {
+// unixSeconds returns the seconds since the UNIX epoch with
+// the fraction of the second.
+func unixSeconds(t time.Time) float64 {
+	return float64(t.Unix()) + float64(t.Nanosecond())/1e9
+}
+
+// KeyNormalizer converts the name of a field to the name of the additional
+// field. Characters not allowed in GELF are removed from the result and the
+// leading underscore is added afterwards.
+type KeyNormalizer func(key string) string
+
+var (
+	// KeepKeys keeps the names as they are.
+	KeepKeys KeyNormalizer = func(key string) string { return key }
+	// SnakeCaseKeys converts camelCase names to snake_case, it is the default.
+	SnakeCaseKeys KeyNormalizer = snakeCase
+	// LowerCaseKeys converts the names to lowercase.
+	LowerCaseKeys KeyNormalizer = strings.ToLower
+)
+
+func snakeCase(k string) string {
 	var key strings.Builder
-	key.Grow(len(k))
+	key.Grow(len(k) + 4)
 	for i, c := range k {
-		if i == 0 && c != '_' {
-			key.WriteRune('_')
+		if unicode.IsUpper(c) {
+			if i > 0 {
+				key.WriteRune('_')
+			}
+			key.WriteRune(unicode.ToLower(c))
+		} else {
+			key.WriteRune(c)
 		}
-		if unicode.IsLetter(c) || unicode.IsNumber(c) ||
+	}
+	return key.String()
+}
+
+// formatKey converts the name of a field to the name of an additional field,
+// which matches `^_[\w\.\-]*$`.
+func formatKey(k string, normalize KeyNormalizer) (string, error) {
+	n := normalize(k)
+	var key strings.Builder
+	key.Grow(len(n) + 1)
+	if !strings.HasPrefix(n, "_") {
+		key.WriteRune('_')
+	}
+	for _, c := range n {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
 			c == '_' || c == '-' || c == '.' {
-			if unicode.IsUpper(c) {
-				if i > 0 {
-					key.WriteRune('_')
-				}
-				key.WriteRune(unicode.ToLower(c))
-			} else {
-				key.WriteRune(c)
-			}
+			key.WriteRune(c)
 		}
 	}
 
-	if key.Len() == 0 {
+	if key.Len() <= 1 {
 		return "", fmt.Errorf("cannot convert to valid key: %s", k)
 	}
 
-	if key.String() == NotAllowedIdFieldName {
+	if strings.EqualFold(key.String(), NotAllowedIdFieldName) {
 		return "", ErrorKeyNotAllowed
 	}
 	return key.String(), nil
 }
 
-// parseLogLevel converts the zeroLog-level to a syslog level
-//   0    Emergency
-//   1    Alert
-//   2    Critical
-//   3    Error
-//   4    Warning
-//   5    Notice
-//   6    Informational
-//   7    Debug
-func parseLogLevel(level string) int {
-	lvl, err := zerolog.ParseLevel(level)
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// uniqueKey appends a number to the key, if it is already used.
+func uniqueKey(evn map[string]interface{}, key string) string {
+	if _, ok := evn[key]; !ok {
+		return key
+	}
+	for i := 1; ; i++ {
+		k := key + "_" + strconv.Itoa(i)
+		if _, ok := evn[k]; !ok {
+			return k
+		}
+	}
+}
+
+// defaultLevelMapping returns the default conversion of the zerolog-levels
+// to syslog levels
+//
+//	0    Emergency
+//	1    Alert
+//	2    Critical
+//	3    Error
+//	4    Warning
+//	5    Notice
+//	6    Informational
+//	7    Debug
+func defaultLevelMapping() map[zerolog.Level]int {
+	return map[zerolog.Level]int{
+		zerolog.TraceLevel: 7,
+		zerolog.DebugLevel: 7,
+		zerolog.InfoLevel:  6,
+		zerolog.WarnLevel:  4,
+		zerolog.ErrorLevel: 3,
+		zerolog.FatalLevel: 2,
+		zerolog.PanicLevel: 1,
+		zerolog.NoLevel:    6,
+	}
+}
+
+// parseLevel returns the zerolog level of the level field,
+// which is either the name or the number of the level.
+func parseLevel(v interface{}) (zerolog.Level, error) {
+	switch l := v.(type) {
+	case string:
+		return zerolog.ParseLevel(l)
+	case json.Number:
+		return zerolog.ParseLevel(l.String())
+	default:
+		return zerolog.NoLevel, fmt.Errorf("invalid level: %v", v)
+	}
+}
+
+// isScalar reports, if the value is allowed as GELF field.
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	default:
+		return true
+	}
+}
+
+// encodeJSON returns the value as JSON string.
+func encodeJSON(v interface{}) string {
+	d, err := json.Marshal(v)
 	if err != nil {
-		return -1
-	}
-	switch lvl {
-	case zerolog.DebugLevel:
-		return 7
-	case zerolog.InfoLevel:
-		return 6
-	case zerolog.WarnLevel:
-		return 4
-	case zerolog.ErrorLevel:
-		return 3
-	case zerolog.FatalLevel:
-		return 2
-	case zerolog.PanicLevel:
-		return 1
+		return fmt.Sprintf("%v", v)
+	}
+	return string(d)
+}
+
+// splitMessage returns the first line of the message, cut to `length`
+// characters, as short message. If the message is cut, it is returned
+// as full message, too.
+func splitMessage(m string, length int) (short, full string) {
+	short = strings.TrimLeft(m, "\r\n")
+	if i := strings.IndexAny(short, "\r\n"); i >= 0 {
+		short = strings.TrimRightFunc(short[:i], unicode.IsSpace)
+	}
+	if short == "" {
+		short = m
+	}
+	if length > 0 && utf8.RuneCountInString(short) > length {
+		short = string([]rune(short)[:length])
+	}
+	if short != m {
+		full = m
+	}
+	return short, full
+}
+
+// formatStack formats an error stack as text, the frames of a stack
+// marshaled by zerolog's pkgerrors are written one per line.
+func formatStack(stack interface{}) string {
+	switch s := stack.(type) {
+	case string:
+		return s
+	case []interface{}:
+		var b strings.Builder
+		for i, f := range s {
+			if i > 0 {
+				b.WriteRune('\n')
+			}
+			frame, ok := f.(map[string]interface{})
+			if !ok {
+				b.WriteString(encodeJSON(f))
+				continue
+			}
+			fmt.Fprintf(&b, "%v\n\t%v:%v", frame["func"], frame["source"], frame["line"])
+		}
+		return b.String()
 	default:
-		return -1
+		return encodeJSON(stack)
 	}
 }
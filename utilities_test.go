@@ -3,12 +3,14 @@ package zgelf
 import (
 	"encoding/json"
 	"github.com/rs/zerolog"
+	"math"
 	"testing"
+	"time"
 )
 
 func Test_convertTime(t *testing.T) {
 	type args struct {
-		i          json.Number
+		v          interface{}
 		timeFormat string
 	}
 	tests := []struct {
@@ -19,17 +21,28 @@ func Test_convertTime(t *testing.T) {
 	}{
 		{"Format Unix", args{json.Number("1234567890"), zerolog.TimeFormatUnix}, float64(1234567890), false},
 		{"Format UnixMs", args{json.Number("1234567890"), zerolog.TimeFormatUnixMs}, 1234567.890, false},
-		{"Format UnixMicro", args{json.Number("1234567890"), zerolog.TimeFormatUnixMicro}, 1234.567, false},
+		{"Format UnixMicro", args{json.Number("1234567890"), zerolog.TimeFormatUnixMicro}, 1234.56789, false},
+		{"Format UnixNano", args{json.Number("1665411478123456789"), zerolog.TimeFormatUnixNano}, 1665411478.123457, false},
 		{"Format UnknownFormat", args{json.Number("1234567890"), "foobar"}, 0, true},
+		{"Format RFC3339", args{"2022-10-10T14:17:58Z", time.RFC3339}, 1665411478, false},
+		{"Format RFC3339 with zone", args{"2022-10-10T16:17:58+02:00", time.RFC3339}, 1665411478, false},
+		{"Format RFC3339Nano", args{"2022-10-10T14:17:58.123456789Z", time.RFC3339Nano}, 1665411478.123457, false},
+		{"Format RFC3339 with fraction", args{"2022-10-10T14:17:58.1234Z", time.RFC3339}, 1665411478.1234, false},
+		{"Format custom layout", args{"10.10.2022 14:17:58.5", "02.01.2006 15:04:05.0"}, 1665411478.5, false},
+		{"Format fallback RFC3339", args{"2022-10-10T14:17:58Z", "02.01.2006"}, 1665411478, false},
+		{"Format string with unix format", args{"2022-10-10T14:17:58Z", zerolog.TimeFormatUnix}, 1665411478, false},
+		{"Format invalid string", args{"yesterday", time.RFC3339}, 0, true},
+		{"Format time", args{time.Unix(1665411478, 250000000), time.RFC3339}, 1665411478.25, false},
+		{"Format unsupported", args{true, time.RFC3339}, 0, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := convertTime(tt.args.i, tt.args.timeFormat)
+			got, err := convertTime(tt.args.v, tt.args.timeFormat)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("convertTime() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if got != tt.want {
+			if math.Abs(got-tt.want) > 1e-6 {
 				t.Errorf("convertTime() got = %v, want %v", got, tt.want)
 			}
 		})
@@ -38,7 +51,8 @@ func Test_convertTime(t *testing.T) {
 
 func Test_formatKey(t *testing.T) {
 	type args struct {
-		k string
+		k         string
+		normalize KeyNormalizer
 	}
 	tests := []struct {
 		name    string
@@ -46,21 +60,29 @@ func Test_formatKey(t *testing.T) {
 		want    string
 		wantErr bool
 	}{
-		{"Format lowercase short key", args{"key"}, "_key", false},
-		{"Format lowercase long key", args{"key_long"}, "_key_long", false},
-		{"Format camelCase long key", args{"keyCamel"}, "_key_camel", false},
-		{"Format PascalCase long key", args{"KeyPascal"}, "_key_pascal", false},
-		{"Format key 1", args{"key.field"}, "_key.field", false},
-		{"Format key 2", args{"-key"}, "_-key", false},
-		{"Format key 3", args{"key.fIEld"}, "_key.f_i_eld", false},
-		{"Format not allowed key 1", args{"Id"}, "", true},
-		{"Format not allowed key 2", args{"id"}, "", true},
-		{"Format not allowed key 3", args{"_id"}, "", true},
-		{"Format empty key", args{""}, "", true},
+		{"Format lowercase short key", args{"key", SnakeCaseKeys}, "_key", false},
+		{"Format lowercase long key", args{"key_long", SnakeCaseKeys}, "_key_long", false},
+		{"Format camelCase long key", args{"keyCamel", SnakeCaseKeys}, "_key_camel", false},
+		{"Format PascalCase long key", args{"KeyPascal", SnakeCaseKeys}, "_key_pascal", false},
+		{"Format key 1", args{"key.field", SnakeCaseKeys}, "_key.field", false},
+		{"Format key 2", args{"-key", SnakeCaseKeys}, "_-key", false},
+		{"Format key 3", args{"key.fIEld", SnakeCaseKeys}, "_key.f_i_eld", false},
+		{"Format underscore key", args{"_key", SnakeCaseKeys}, "_key", false},
+		{"Format invalid characters", args{"käy $1", SnakeCaseKeys}, "_ky1", false},
+		{"Format keep camelCase", args{"userId", KeepKeys}, "_userId", false},
+		{"Format keep invalid characters", args{"user Id!", KeepKeys}, "_userId", false},
+		{"Format lowercase", args{"userId", LowerCaseKeys}, "_userid", false},
+		{"Format custom", args{"userId", func(k string) string { return "app." + k }}, "_app.userId", false},
+		{"Format not allowed key 1", args{"Id", SnakeCaseKeys}, "", true},
+		{"Format not allowed key 2", args{"id", SnakeCaseKeys}, "", true},
+		{"Format not allowed key 3", args{"_id", SnakeCaseKeys}, "", true},
+		{"Format not allowed key 4", args{"Id", KeepKeys}, "", true},
+		{"Format empty key", args{"", SnakeCaseKeys}, "", true},
+		{"Format invalid key", args{"$", SnakeCaseKeys}, "", true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := formatKey(tt.args.k)
+			got, err := formatKey(tt.args.k, tt.args.normalize)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("formatKey() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -72,27 +94,108 @@ func Test_formatKey(t *testing.T) {
 	}
 }
 
-func Test_parseLogLevel(t *testing.T) {
-	type args struct {
-		level string
+func Test_defaultLevelMapping(t *testing.T) {
+	tests := []struct {
+		name  string
+		level zerolog.Level
+		want  int
+	}{
+		{"map trace level", zerolog.TraceLevel, 7},
+		{"map debug level", zerolog.DebugLevel, 7},
+		{"map info level", zerolog.InfoLevel, 6},
+		{"map warn level", zerolog.WarnLevel, 4},
+		{"map error level", zerolog.ErrorLevel, 3},
+		{"map fatal level", zerolog.FatalLevel, 2},
+		{"map panic level", zerolog.PanicLevel, 1},
+		{"map no level", zerolog.NoLevel, 6},
+	}
+	mapping := defaultLevelMapping()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, ok := mapping[tt.level]; !ok || got != tt.want {
+				t.Errorf("defaultLevelMapping()[%v] = %v, want %v", tt.level, got, tt.want)
+			}
+		})
+	}
+	if _, ok := mapping[zerolog.Disabled]; ok {
+		t.Errorf("defaultLevelMapping() must not map the disabled level")
+	}
+}
+
+func Test_parseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   interface{}
+		want    zerolog.Level
+		wantErr bool
+	}{
+		{"parse trace level", "trace", zerolog.TraceLevel, false},
+		{"parse info level", "info", zerolog.InfoLevel, false},
+		{"parse panic level", "panic", zerolog.PanicLevel, false},
+		{"parse no level", "", zerolog.NoLevel, false},
+		{"parse numeric level", json.Number("2"), zerolog.WarnLevel, false},
+		{"parse numeric string level", "-1", zerolog.TraceLevel, false},
+		{"parse invalid level", "invalid", zerolog.NoLevel, true},
+		{"parse invalid numeric level", json.Number("1.5"), zerolog.NoLevel, true},
+		{"parse invalid type", true, zerolog.NoLevel, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLevel(tt.level)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseLevel() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseLevel() = %v, want %v", got, tt.want)
+			}
+		})
 	}
+}
+
+func Test_splitMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		m         string
+		length    int
+		wantShort string
+		wantFull  string
+	}{
+		{"single line", "request done", 250, "request done", ""},
+		{"multi line", "request failed\nstatus: 500\n", 250, "request failed", "request failed\nstatus: 500\n"},
+		{"crlf", "request failed \r\nstatus: 500", 250, "request failed", "request failed \r\nstatus: 500"},
+		{"leading newline", "\nrequest failed", 250, "request failed", "\nrequest failed"},
+		{"too long", "äbcdefgh", 4, "äbcd", "äbcdefgh"},
+		{"no limit", "abcdefgh", 0, "abcdefgh", ""},
+		{"only newlines", "\n\n", 250, "\n\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			short, full := splitMessage(tt.m, tt.length)
+			if short != tt.wantShort || full != tt.wantFull {
+				t.Errorf("splitMessage() = %q, %q, want %q, %q", short, full, tt.wantShort, tt.wantFull)
+			}
+		})
+	}
+}
+
+func Test_formatStack(t *testing.T) {
 	tests := []struct {
-		name string
-		args args
-		want int
+		name  string
+		stack interface{}
+		want  string
 	}{
-		{"parse debug level", args{"debug"}, 7},
-		{"parse info level", args{"info"}, 6},
-		{"parse warn level", args{"warn"}, 4},
-		{"parse error level", args{"error"}, 3},
-		{"parse fatal level", args{"fatal"}, 2},
-		{"parse panic level", args{"panic"}, 1},
-		{"parse invalid level", args{"invalid"}, -1},
+		{"string", "goroutine 1 [running]:\nmain.main()", "goroutine 1 [running]:\nmain.main()"},
+		{"frames", []interface{}{
+			map[string]interface{}{"func": "main.run", "source": "main.go", "line": "12"},
+			map[string]interface{}{"func": "main.main", "source": "main.go", "line": "5"},
+		}, "main.run\n\tmain.go:12\nmain.main\n\tmain.go:5"},
+		{"other", map[string]interface{}{"a": "b"}, `{"a":"b"}`},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := parseLogLevel(tt.args.level); got != tt.want {
-				t.Errorf("parseLogLevel() = %v, want %v", got, tt.want)
+			if got := formatStack(tt.stack); got != tt.want {
+				t.Errorf("formatStack() = %q, want %q", got, tt.want)
 			}
 		})
 	}
@@ -0,0 +1,94 @@
+package zgelf
+
+import (
+	"fmt"
+	"sync"
+)
+
+// sequencer passes the processed messages to the buffer. If the order is
+// preserved, a message is passed after all messages taken from the queue
+// before it, regardless which worker finished first.
+type sequencer struct {
+	mu       sync.Mutex
+	next     uint64
+	pending  map[uint64][]byte
+	preserve bool
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{
+		pending:  make(map[uint64][]byte),
+		preserve: true,
+	}
+}
+
+func (s *sequencer) setPreserve(preserve bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.preserve = preserve
+}
+
+// deliver has to be called for every event taken from the queue, `d` is nil
+// if the event was dropped. The messages which are ready are passed to `add`.
+func (s *sequencer) deliver(seq uint64, d []byte, add func(d []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.preserve && d != nil {
+		add(d)
+		d = nil
+	}
+	// the sequence is tracked in both modes, so the
+	// order can be enabled again at any time
+	s.pending[seq] = d
+	for {
+		m, ok := s.pending[s.next]
+		if !ok {
+			return
+		}
+		delete(s.pending, s.next)
+		s.next++
+		if m != nil {
+			add(m)
+		}
+	}
+}
+
+// SetWorkers sets the number of routines, which process the queued events
+// concurrently. It can be changed at any time.
+func (w *GelfWriter) SetWorkers(n int) error {
+	if n < 1 {
+		return fmt.Errorf("invalid number of workers: %d", n)
+	}
+
+	w.poolMu.Lock()
+	defer w.poolMu.Unlock()
+
+	if n > w.workers {
+		for i := w.workers; i < n; i++ {
+			go w.worker()
+		}
+	} else if n < w.workers {
+		w.queue.release(w.workers - n)
+	}
+	w.workers = n
+	return nil
+}
+
+// SetPreserveOrder defines, if the messages are sent in the order they were
+// written, although they are processed concurrently. It is enabled by default.
+func (w *GelfWriter) SetPreserveOrder(preserve bool) {
+	w.sequencer.setPreserve(preserve)
+}
+
+func (w *GelfWriter) worker() {
+	for {
+		evt, seq, ok := w.queue.pop()
+		if !ok {
+			return
+		}
+		w.sequencer.deliver(seq, w.process(evt), w.bufferMessage)
+		w.queue.done()
+	}
+}
@@ -2,20 +2,31 @@ package zgelf
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
 )
 
 const (
-	tempLogFileRegex      = "^log_([[:digit:]]+).[gz|log]$"
+	tempLogFileRegex      = `^log_([[:digit:]]+)\.(gz|log)$`
+	spillBatchSize        = 64 * 1024
+	spillQueueSize        = 1024
+	spillDelay            = time.Second
+	maxBufferedSize       = 4 * 1024 * 1024
+	defaultMaxDepth       = 5
+	defaultShortLength    = 250
 	GelfVersion           = "1.1"
 	ErrorFieldName        = "_err"
 	ErrorStackFieldName   = "_err_stack"
@@ -28,45 +39,171 @@ const (
 	ShortMessageFieldName = "short_message"
 	VersionFieldName      = "version"
 	LineNumberFieldName   = "_line"
+	CallerFieldName       = "_caller"
+	TruncatedFieldName    = "_truncated"
 	NotAllowedIdFieldName = "_id"
+	OriginalIdFieldName   = "_orig_id"
+	// ProcessingErrorFieldName holds the reason, why an
+	// event could not be processed, see DeadLetterHandler.
+	ProcessingErrorFieldName = "_processing_error"
 )
 
 var ErrorKeyNotAllowed = errors.New("key `id` is not allowed")
 
+// IdPolicy defines the handling of the field `id`,
+// which is not allowed in GELF messages.
+type IdPolicy string
+
+const (
+	// IdRename renames the field to `_orig_id`.
+	IdRename = IdPolicy("rename")
+	// IdDropField removes the field from the message.
+	IdDropField = IdPolicy("drop_field")
+	// IdDropEvent discards the whole event.
+	IdDropEvent = IdPolicy("drop_event")
+)
+
+func validateIdPolicy(policy IdPolicy) error {
+	switch policy {
+	case IdRename, IdDropField, IdDropEvent:
+		return nil
+	default:
+		return fmt.Errorf("unknown id policy: %s", policy)
+	}
+}
+
 type GelfWriter struct {
-	transport   transport
-	tempLogPath string
-	host        string
-	queue       chan map[string]interface{}
-	wgProcess   sync.WaitGroup
-	wgFlush     sync.WaitGroup
-	buffer      *logBuffer
-	ticker      *time.Ticker
+	transport transport
+	opts      options
+	spool     *spool
+	queue     *eventQueue
+	sequencer *sequencer
+	workers   int
+	poolMu    sync.Mutex
+	wgFlush   sync.WaitGroup
+	buffer    *logBuffer
+	spill     *logBuffer
+	spills    chan map[string]interface{}
+	wgSpill   sync.WaitGroup
+	sendMu    sync.Mutex
+	flush     chan struct{}
+	done      chan struct{}
+	ticker    *time.Ticker
+	dropped   uint64
+	// errorHandler holds a *ErrorHandler
+	errorHandler atomic.Value
+	// inflight is the buffer handed to the transport, sent signals the
+	// end of a send to the workers waiting for the transport
+	inflightMu sync.Mutex
+	inflight   *logBuffer
+	sent       chan struct{}
+	stopOnce   sync.Once
+	stopErr    error
 }
 
-// New crates a new GelfWriter which can be used as a sink
-// for zerolog. The parameter `host` is set as the appropriate field
-// in the GELF-package, the server ist configured with tha parameters
-// `serverUrl` and `serverPort` and mode. Transport over http(s) is the default.
-func New(host, tmpLogPath string, trans transport) *GelfWriter {
+// New crates a new GelfWriter which can be used as a sink for zerolog.
+// The messages are sent with the transport `trans`, the writer is
+// configured with the options, e.g.
+//
+//	w, err := zgelf.New(t, zgelf.WithHost("web-01"), zgelf.WithSpoolDir("/var/spool/zgelf"))
+func New(trans transport, opts ...Option) (*GelfWriter, error) {
+	o := options{
+		spoolCompression: true,
+		queueSize:        defaultQueueSize,
+		overflowPolicy:   OverflowBlock,
+		workers:          runtime.GOMAXPROCS(0),
+		preserveOrder:    true,
+		staticFields:     make(map[string]interface{}),
+		fieldMapping:     make(map[string]string),
+		minLevel:         zerolog.TraceLevel,
+		levelMapping:     defaultLevelMapping(),
+		idPolicy:         IdRename,
+		maxDepth:         defaultMaxDepth,
+		keyNormalizer:    SnakeCaseKeys,
+		shortLength:      defaultShortLength,
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if o.host == "" {
+		o.host, _ = os.Hostname()
+	}
 	w := GelfWriter{
-		transport:   trans,
-		tempLogPath: tmpLogPath,
-		host:        host,
-		buffer:      NewLogBuffer(),
-		queue:       make(chan map[string]interface{}, 500),
+		transport: trans,
+		opts:      o,
+		buffer:    NewLogBuffer(),
+		spill:     NewLogBuffer(),
+		queue:     newEventQueue(o.queueSize),
+		sequencer: newSequencer(),
+		flush:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		sent:      make(chan struct{}),
+	}
+	// the static fields are flattened once, with the options applied
+	static := make(map[string]interface{}, len(o.staticFields))
+	if err := w.formatFields(static, o.staticFields); err != nil {
+		return nil, fmt.Errorf("invalid static field: %w", err)
 	}
+	w.opts.staticFields = static
+
+	w.queue.setPolicy(o.overflowPolicy)
+	w.sequencer.setPreserve(o.preserveOrder)
+	w.SetErrorHandler(o.errorHandler)
 
-	if trans.BufferTime() > 0 {
-		w.ticker = time.NewTicker(trans.BufferTime())
+	if o.spoolDir != "" {
+		s, err := newSpool(o.spoolDir)
+		if err != nil {
+			return nil, err
+		}
+		s.compress = o.spoolCompression
+		if err := s.setLimits(o.spoolLimits); err != nil {
+			return nil, err
+		}
+		w.spool = s
+		w.spills = make(chan map[string]interface{}, spillQueueSize)
+		w.wgSpill.Add(1)
+		go w.spiller()
+
+		// send the logs left by a previous process
+		w.wgFlush.Add(1)
 		go func() {
-			for range w.ticker.C {
-				w.Flush(true)
-			}
+			defer w.wgFlush.Done()
+			w.sendTemporaryLogs()
 		}()
 	}
-	go w.worker()
-	return &w
+
+	if o.flushInterval > 0 {
+		w.startTicker(o.flushInterval)
+	} else if trans.BufferTime() > 0 {
+		w.startTicker(trans.BufferTime())
+	}
+	go w.flusher()
+	_ = w.SetWorkers(o.workers)
+	return &w, nil
+}
+
+// NewWriter crates a new GelfWriter which can be used as a sink
+// for zerolog. The parameter `host` is set as the appropriate field
+// in the GELF-package. If `tmpLogPath` is set, logs which cannot be
+// sent are saved in this directory and sent again, once the transport
+// succeeds. If the directory cannot be used, the writer drops the logs
+// which cannot be sent.
+//
+// Deprecated: use New with WithHost and WithSpoolDir, which returns the error.
+func NewWriter(host, tmpLogPath string, trans transport) *GelfWriter {
+	opts := []Option{WithSpoolDir(tmpLogPath)}
+	if host != "" {
+		opts = append(opts, WithHost(host))
+	}
+	w, err := New(trans, opts...)
+	if err != nil {
+		// fall back to a writer without temporary logs
+		w, _ = New(trans, opts[1:]...)
+		w.handleError(ErrorKindSpool, err)
+	}
+	return w
 }
 
 func (w *GelfWriter) Write(p []byte) (n int, err error) {
@@ -74,8 +211,15 @@ func (w *GelfWriter) Write(p []byte) (n int, err error) {
 	d := json.NewDecoder(bytes.NewReader(p))
 	d.UseNumber()
 	err = d.Decode(&evt)
+	if err == nil && evt == nil {
+		err = errors.New("event is not an object")
+	}
 	if err != nil {
-		return n, fmt.Errorf("cannot decode event: %s", err)
+		err = fmt.Errorf("cannot decode event: %w", err)
+		if dl := w.deadLetter(p, err); dl != nil {
+			w.bufferMessage(dl)
+		}
+		return n, err
 	}
 
 	// ignore logs without message, since they are no allowed in GELF
@@ -83,158 +227,717 @@ func (w *GelfWriter) Write(p []byte) (n int, err error) {
 	if !ok || val == "" {
 		return len(p), nil
 	}
-	l := len(w.queue)
-	if l > 0 && l%50 == 0 {
-		fmt.Printf("###queue: %d\n", l)
+
+	// GELF takes the receive time of the server, if the timestamp is
+	// missing, use the time the event was written instead
+	if _, ok := evt[zerolog.TimestampFieldName]; !ok {
+		evt[zerolog.TimestampFieldName] = time.Now()
 	}
 
-	w.queue <- evt
+	if r, policy := w.queue.push(evt); r != nil {
+		if policy == OverflowSpill {
+			w.spillEvent(r)
+		} else {
+			atomic.AddUint64(&w.dropped, 1)
+		}
+	}
 	return len(p), nil
 }
 
+// SetQueueSize sets the number of events, which are queued for processing.
+// If the queue is full, the overflow policy is applied.
+func (w *GelfWriter) SetQueueSize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("invalid queue size: %d", size)
+	}
+	w.queue.setSize(size)
+	return nil
+}
+
+// SetOverflowPolicy sets the handling of events, which do not fit into the
+// queue. OverflowBlock is the default, it blocks the caller of Write.
+func (w *GelfWriter) SetOverflowPolicy(policy OverflowPolicy) error {
+	if err := validateOverflowPolicy(policy); err != nil {
+		return err
+	}
+	w.queue.setPolicy(policy)
+	return nil
+}
+
+// DroppedMessages returns the number of messages, which were discarded
+// because the queue was full or they could neither be sent nor saved to
+// the temporary logs.
+func (w *GelfWriter) DroppedMessages() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// ShutdownError is returned by Shutdown, if not all messages
+// could be sent before the context was done.
+type ShutdownError struct {
+	// Spooled is the number of messages saved to the temporary logs.
+	Spooled int
+	// Dropped is the number of messages lost.
+	Dropped int
+	Err     error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("shutdown incomplete, %d messages spooled, %d dropped: %s",
+		e.Spooled, e.Dropped, e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
 // Close waits for the queue to empty and
 // all currently processed log entries to be finished,
-// finally flushes the buffer
+// finally flushes the buffer and closes the transport
 func (w *GelfWriter) Close() {
-	time.Sleep(time.Millisecond * 10)
+	_ = w.Shutdown(context.Background())
+}
+
+// Shutdown processes and sends all queued events and closes the writer.
+// If the context is done before, the messages not handed to the transport
+// yet are saved to the temporary logs and a *ShutdownError is returned,
+// which reports the number of messages spooled and dropped. Events the
+// workers are processing at that time are still finished and saved.
+// Calling Shutdown again returns the result of the first call.
+func (w *GelfWriter) Shutdown(ctx context.Context) error {
+	w.stopOnce.Do(func() {
+		w.stopErr = w.shutdown(ctx)
+	})
+	return w.stopErr
+}
 
-	// wait for queue to empty
-	for len(w.queue) > 0 {
+func (w *GelfWriter) shutdown(ctx context.Context) error {
+	err := w.queue.wait(ctx)
+	w.queue.close()
+	if err == nil {
+		err = w.FlushContext(ctx)
 	}
-	close(w.queue)
+	close(w.done)
+	w.wgSpill.Wait()
 
-	// wait for process routines to finish
-	w.wgProcess.Wait()
+	if ctx.Err() != nil {
+		spooled, dropped := w.abandon()
+		atomic.AddUint64(&w.dropped, uint64(dropped))
+		return &ShutdownError{Spooled: spooled, Dropped: dropped, Err: ctx.Err()}
+	}
+	w.collectSpills()
+	w.flushSpill()
 
-	// flush buffer
-	w.Flush(true)
+	w.wgFlush.Wait()
+	if c, ok := w.transport.(io.Closer); ok {
+		_ = c.Close()
+	}
+	return err
 }
 
-// Flush flushes the send buffer
-func (w *GelfWriter) Flush(block bool) {
-	if block {
-		w.wgProcess.Wait()
-		w.wgFlush.Wait() // wait for running, non blocking operations
+// FlushContext waits for all queued events to be processed and sends the
+// buffer. It returns the error of the context, if it is done before. The
+// transport is not interrupted, a buffer already handed to it is still sent
+// or saved to the temporary logs in the background, unless the writer is
+// shut down, which saves the messages not sent yet.
+func (w *GelfWriter) FlushContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	if w.buffer.Size() == 0 {
-		return
+	if err := w.queue.wait(ctx); err != nil {
+		return err
+	}
+	w.collectSpills()
+	w.flushSpill()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.send()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// abandon saves all messages, which are not sent yet, to the temporary logs,
+// including those left in the buffer the transport is still sending. It
+// returns the number of spooled and dropped messages.
+func (w *GelfWriter) abandon() (spooled, dropped int) {
+	events := w.queue.drain()
+	// the events taken by the workers already are added to the buffer,
+	// once they are processed, they are older than the drained ones. The
+	// workers do not wait for the transport after the shutdown.
+	_ = w.queue.wait(context.Background())
+	for _, evt := range events {
+		if d := w.process(evt); d != nil {
+			w.spill.Add(d)
+		}
 	}
+	w.collectSpills()
 
-	c := w.buffer.Copy()
-	w.buffer.Clear()
+	buffers := []*logBuffer{w.buffer.Take(), w.spill.Take()}
+	if b := w.takeInflight(); b != nil {
+		buffers = append([]*logBuffer{b}, buffers...)
+	}
+	for _, b := range buffers {
+		n := len(b.buffers)
+		if n == 0 {
+			continue
+		}
+		if w.spool != nil && w.spool.write(b) == nil {
+			spooled += n
+		} else {
+			dropped += n
+		}
+	}
+	return spooled, dropped
+}
 
+// Flush flushes the send buffer, if `block` is set, it waits for
+// all queued events to be processed and sent. Otherwise the buffer
+// is sent in the background.
+func (w *GelfWriter) Flush(block bool) {
 	if block {
-		_ = w.sendBuffer(c)
-	} else {
-		go func() {
-			w.wgFlush.Add(1)
-			defer w.wgFlush.Done()
+		_ = w.FlushContext(context.Background())
+		return
+	}
 
-			if err := w.sendBuffer(c); err == nil {
-				w.sendTemporaryLogs()
-			}
-		}()
+	select {
+	case w.flush <- struct{}{}:
+	default:
+		// a flush is already pending
 	}
 }
 
 // SetMaxBufferTime sets the time after the log-buffer is flushed,
 // regardless of its size.
 func (w *GelfWriter) SetMaxBufferTime(bufferTime time.Duration) {
-	if w.ticker != nil {
+	if w.ticker == nil {
+		w.startTicker(bufferTime)
+	} else if bufferTime > 0 {
+		w.ticker.Reset(bufferTime)
+	} else {
 		w.ticker.Stop()
 	}
-	w.ticker = time.NewTicker(bufferTime)
 }
 
-func (w *GelfWriter) worker() {
-	for data := range w.queue {
-		w.wgProcess.Add(1)
-		go func(evt map[string]interface{}) {
-			defer w.wgProcess.Done()
-			w.process(evt)
-		}(data)
+func (w *GelfWriter) startTicker(bufferTime time.Duration) {
+	if bufferTime <= 0 {
+		return
+	}
+	t := time.NewTicker(bufferTime)
+	w.ticker = t
+	go func() {
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				w.Flush(false)
+			case <-w.done:
+				return
+			}
+		}
+	}()
+}
+
+// flusher sends the buffer in the background, whenever it is requested.
+func (w *GelfWriter) flusher() {
+	for {
+		select {
+		case <-w.flush:
+			_ = w.send()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// send takes the content of the buffer and sends it. Only one buffer
+// is sent at a time, so the messages arrive in the order they were
+// buffered.
+func (w *GelfWriter) send() error {
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+	defer w.signalSent()
+
+	if w.buffer.Size() == 0 {
+		return nil
+	}
+	b := w.buffer.Take()
+	w.inflightMu.Lock()
+	w.inflight = b
+	w.inflightMu.Unlock()
+
+	err := w.sendBuffer(b)
+	if err == nil {
+		w.sendTemporaryLogs()
+	}
+	return err
+}
+
+// sentSignal returns a channel, which is closed after the next send.
+func (w *GelfWriter) sentSignal() <-chan struct{} {
+	w.inflightMu.Lock()
+	defer w.inflightMu.Unlock()
+
+	return w.sent
+}
+
+func (w *GelfWriter) signalSent() {
+	w.inflightMu.Lock()
+	defer w.inflightMu.Unlock()
+
+	close(w.sent)
+	w.sent = make(chan struct{})
+}
+
+// takeInflight returns the messages left in the buffer the transport is
+// sending. They are not saved by sendBuffer anymore, if the transport fails.
+func (w *GelfWriter) takeInflight() *logBuffer {
+	w.inflightMu.Lock()
+	b := w.inflight
+	w.inflight = nil
+	w.inflightMu.Unlock()
+
+	if b == nil {
+		return nil
+	}
+	return b.Copy()
+}
+
+// releaseInflight marks the buffer as sent, it returns false
+// if it was taken by takeInflight in the meantime.
+func (w *GelfWriter) releaseInflight(buffer *logBuffer) bool {
+	w.inflightMu.Lock()
+	defer w.inflightMu.Unlock()
+
+	if w.inflight != buffer {
+		return false
+	}
+	w.inflight = nil
+	return true
+}
+
+// process converts the event to a serialized GELF message,
+// it returns nil if the event is dropped. Malformed events
+// are passed to the dead-letter handler.
+func (w *GelfWriter) process(evt map[string]interface{}) (d []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			d = w.deadLetter(marshalEvent(evt), fmt.Errorf("panic while processing event: %v", r))
+		}
+	}()
+
+	evn, err := w.convert(evt)
+	if err != nil {
+		return w.deadLetter(marshalEvent(evt), err)
+	}
+	if evn == nil {
+		return nil
+	}
+	w.protect(evn)
+	w.limitFields(evn)
+	d, err = json.Marshal(evn)
+	if err == nil && w.opts.maxMessageSize > 0 && len(d) > w.opts.maxMessageSize {
+		d, err = w.shrink(evn, d)
+	}
+	if err != nil {
+		return w.deadLetter(marshalEvent(evt), &Error{Kind: ErrorKindMarshal, Err: err})
+	}
+	return d
+}
+
+// marshalEvent serializes the event for the dead-letter handler.
+func marshalEvent(evt map[string]interface{}) []byte {
+	d, err := json.Marshal(evt)
+	if err != nil {
+		return []byte(fmt.Sprintf("%v", evt))
+	}
+	return d
+}
+
+// spillEvent hands an event, which does not fit into the queue, to the
+// spiller. It never blocks, the event is dropped if the spiller falls
+// behind or no temporary log path is set.
+func (w *GelfWriter) spillEvent(evt map[string]interface{}) {
+	select {
+	case w.spills <- evt:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+}
+
+// spiller writes the spilled events to the temporary logs in the
+// background. The events are collected and written in batches, a batch
+// is written when it is full or after a delay.
+func (w *GelfWriter) spiller() {
+	defer w.wgSpill.Done()
+
+	t := time.NewTimer(spillDelay)
+	t.Stop()
+	defer t.Stop()
+	for {
+		select {
+		case evt := <-w.spills:
+			d := w.process(evt)
+			if d == nil {
+				continue
+			}
+			pending := w.spill.Size() > 0
+			w.spill.Add(d)
+			if w.spill.Size() > spillBatchSize {
+				w.flushSpill()
+			} else if !pending {
+				t.Reset(spillDelay)
+			}
+		case <-t.C:
+			w.flushSpill()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// collectSpills adds the spilled events, which are
+// not taken by the spiller yet, to the spill buffer.
+func (w *GelfWriter) collectSpills() {
+	for {
+		select {
+		case evt := <-w.spills:
+			if d := w.process(evt); d != nil {
+				w.spill.Add(d)
+			}
+		default:
+			return
+		}
 	}
 }
 
-func (w *GelfWriter) process(evt map[string]interface{}) {
+// flushSpill writes the spilled events to the temporary logs.
+func (w *GelfWriter) flushSpill() {
+	if w.spill.Size() > 0 {
+		b := w.spill.Take()
+		if !w.writeTemporaryLog(b) {
+			atomic.AddUint64(&w.dropped, uint64(len(b.buffers)))
+		}
+	}
+}
+
+// convert maps the zerolog event to a GELF message,
+// it returns nil if the event has to be dropped.
+func (w *GelfWriter) convert(evt map[string]interface{}) (map[string]interface{}, error) {
 	evn := make(map[string]interface{}, len(evt))
+	var fields []string
+	var stack interface{}
 	for k, v := range evt {
 		switch k {
 		case zerolog.LevelFieldName:
-			lvl := parseLogLevel(v.(string))
-			if lvl < 0 {
-				return
+			l, err := parseLevel(v)
+			if err != nil {
+				return nil, err
+			}
+			if l < w.opts.minLevel {
+				return nil, nil
+			}
+			lvl, ok := w.opts.levelMapping[l]
+			if !ok {
+				return nil, nil
 			}
 			evn[LevelFieldName] = lvl
-			evn[LogLevelFieldName] = v
+			evn[LogLevelFieldName] = l.String()
 		case zerolog.TimestampFieldName:
-			t, err := convertTime(v.(json.Number), zerolog.TimeFieldFormat)
-			if err == nil {
-				evn[TimestampFieldName] = t
+			t, err := convertTime(v, zerolog.TimeFieldFormat)
+			if err != nil {
+				return nil, err
 			}
+			evn[TimestampFieldName] = t
 		case zerolog.MessageFieldName:
-			evn[ShortMessageFieldName] = v
+			m, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid message: %v", v)
+			}
+			evn[ShortMessageFieldName] = m
 		case zerolog.CallerFieldName:
-			if f, l, err := parseCaller(v.(string)); err == nil {
+			c, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid caller: %v", v)
+			}
+			if f, l, err := parseCaller(c); err == nil {
 				evn[FileFieldName] = f
 				evn[LineNumberFieldName] = l
+			} else {
+				evn[CallerFieldName] = c
 			}
 		case zerolog.ErrorFieldName:
-			evn[ErrorFieldName] = v
+			w.flatten(ErrorFieldName, v, func(name string, v interface{}) {
+				// the names of nested fields are taken from the event
+				if key, err := formatKey(name, w.opts.keyNormalizer); err == nil {
+					evn[uniqueKey(evn, key)] = v
+				}
+			})
 		case zerolog.ErrorStackFieldName:
-			evn[ErrorStackFieldName] = v
+			if w.opts.stackInFull {
+				stack = v
+			} else if isScalar(v) {
+				evn[ErrorStackFieldName] = v
+			} else {
+				evn[ErrorStackFieldName] = encodeJSON(v)
+			}
 		default:
-			key, err := formatKey(k)
+			fields = append(fields, k)
+		}
+	}
+
+	if m, ok := evn[ShortMessageFieldName].(string); ok {
+		// redact the whole message, a match may be cut by the split
+		if r, ok := w.redact(m); ok {
+			m = r
+		}
+		short, full := splitMessage(m, w.opts.shortLength)
+		if stack != nil {
+			full = m + "\n\n" + formatStack(stack)
+		}
+		evn[ShortMessageFieldName] = short
+		if full != "" {
+			evn[FullMessageFieldName] = full
+		}
+	}
+
+	// the additional fields are added in order, so collisions
+	// after the normalization are resolved deterministically
+	sort.Strings(fields)
+	for _, k := range fields {
+		drop := false
+		w.flatten(k, evt[k], func(path string, v interface{}) {
+			key, err := w.fieldKey(path)
 			if err == ErrorKeyNotAllowed {
-				return
+				switch w.opts.idPolicy {
+				case IdDropEvent:
+					drop = true
+					return
+				case IdDropField:
+					return
+				default:
+					key = OriginalIdFieldName
+				}
 			} else if err != nil {
-				continue
-			}
-			if key != k {
-				evn[key] = v
+				return
 			}
+			evn[uniqueKey(evn, key)] = v
+		})
+		if drop {
+			return nil, nil
+		}
+	}
+	if _, ok := evn[LevelFieldName]; !ok {
+		if lvl, ok := w.opts.levelMapping[zerolog.NoLevel]; ok {
+			evn[LevelFieldName] = lvl
 		}
 	}
 	evn[VersionFieldName] = GelfVersion
-	evn[HostFieldName] = w.host
+	evn[HostFieldName] = w.opts.host
+
+	for _, fields := range w.opts.dynamicFields {
+		// invalid names of dynamic fields are skipped
+		_ = w.formatFields(evn, fields())
+	}
+	for k, v := range w.opts.staticFields {
+		if _, ok := evn[k]; !ok {
+			evn[k] = v
+		}
+	}
+	return evn, nil
+}
 
-	w.bufferEvent(evn)
+// formatFields flattens the fields and adds them to the message with
+// formatted names, unless the message has a field of the same name. It
+// returns the first name, which cannot be converted to a valid key, the
+// other fields are added anyway.
+func (w *GelfWriter) formatFields(evn, fields map[string]interface{}) error {
+	var err error
+	for _, k := range sortedKeys(fields) {
+		w.flatten(k, fields[k], func(path string, v interface{}) {
+			key, e := formatKey(path, w.opts.keyNormalizer)
+			if e != nil {
+				if err == nil {
+					err = fmt.Errorf("%q: %w", path, e)
+				}
+				return
+			}
+			if _, ok := evn[key]; !ok {
+				evn[key] = v
+			}
+		})
+	}
+	return err
 }
 
-func (w *GelfWriter) bufferEvent(evt map[string]interface{}) {
-	d, err := json.Marshal(evt)
-	if err != nil {
-		fmt.Printf("error marshalling GELF data: %s", err)
-		return
+// fieldKey returns the name of the additional field for a field of
+// the event. Mapped names are used as they are, others are normalized.
+func (w *GelfWriter) fieldKey(name string) (string, error) {
+	if m, ok := w.opts.fieldMapping[name]; ok {
+		return formatKey(m, KeepKeys)
+	}
+	return formatKey(name, w.opts.keyNormalizer)
+}
+
+// flatten passes the scalar values of a field to `add`. The values of nested
+// objects and arrays get names separated by dots, e.g. `http.request.method`
+// or `tags.0`. Values nested deeper than the max depth and, if configured,
+// arrays are passed as JSON strings.
+func (w *GelfWriter) flatten(name string, v interface{}, add func(name string, v interface{})) {
+	var walk func(name string, v interface{}, depth int)
+	walk = func(name string, v interface{}, depth int) {
+		switch n := v.(type) {
+		case map[string]interface{}:
+			if len(n) == 0 || depth >= w.opts.maxDepth {
+				add(name, encodeJSON(v))
+				return
+			}
+			for _, k := range sortedKeys(n) {
+				walk(name+"."+k, n[k], depth+1)
+			}
+		case []interface{}:
+			if len(n) == 0 || depth >= w.opts.maxDepth || w.opts.arraysAsJSON {
+				add(name, encodeJSON(v))
+				return
+			}
+			for i, c := range n {
+				walk(name+"."+strconv.Itoa(i), c, depth+1)
+			}
+		default:
+			add(name, v)
+		}
 	}
+	walk(name, v, 0)
+}
 
+// bufferMessage adds the message to the send buffer. If the buffer grows
+// too large, because the transport is slow, the workers wait until it is
+// sent. They never call the transport themselves, so the shutdown does
+// not have to wait for it.
+func (w *GelfWriter) bufferMessage(d []byte) {
 	w.buffer.Add(d)
+	for w.buffer.Size() > maxBufferedSize {
+		sent := w.sentSignal()
+		w.Flush(false)
+		select {
+		case <-sent:
+		case <-w.done:
+			// the writer is shut down, the buffer is left to Shutdown
+			return
+		}
+	}
 	if w.isBufferSizeExceeded() {
 		w.Flush(false)
 	}
 }
 
 func (w *GelfWriter) isBufferSizeExceeded() bool {
-	return w.buffer.size > w.transport.BufferSize()
+	return w.buffer.Size() > w.transport.BufferSize()
 }
 
 func (w *GelfWriter) sendBuffer(buffer *logBuffer) error {
 	err := w.transport.SendBuffer(buffer)
-	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "error sending log: %s", err)
-		if w.tempLogPath != "" {
-			w.writeTemporaryLog(buffer)
+	if !w.releaseInflight(buffer) {
+		// the writer was shut down, the messages are saved already
+		return err
+	}
+	if err == nil {
+		return nil
+	}
+
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		// only the messages which failed are saved
+		for _, d := range w.handleSendError(sendErr) {
+			buffer.Add(d)
 		}
+	} else {
+		w.handleError(ErrorKindSend, err)
+	}
+	if buffer.Size() > 0 && !w.writeTemporaryLog(buffer) {
+		atomic.AddUint64(&w.dropped, uint64(len(buffer.buffers)))
 	}
 	return err
 }
 
-func (w *GelfWriter) writeTemporaryLog(buffer *logBuffer) {
-	// TODO: implement log save to file
+// handleSendError reports the errors of the single messages and counts the
+// rejected messages as dropped. It returns the messages to send again.
+func (w *GelfWriter) handleSendError(err *SendError) [][]byte {
+	for _, e := range err.Errors {
+		w.handleError(ErrorKindSend, e)
+	}
+	atomic.AddUint64(&w.dropped, uint64(len(err.Rejected)))
+	return err.Failed
+}
+
+// SetSpoolCompression enables or disables the gzip compression
+// of the temporary log files, it is enabled by default.
+func (w *GelfWriter) SetSpoolCompression(enable bool) {
+	if w.spool != nil {
+		w.spool.compress = enable
+	}
 }
 
+// SetSpoolLimits restricts the size, number and age of the temporary
+// log files. The limits are applied to the existing files immediately.
+func (w *GelfWriter) SetSpoolLimits(limits SpoolLimits) error {
+	if w.spool == nil {
+		return fmt.Errorf("no temporary log path configured")
+	}
+	return w.spool.setLimits(limits)
+}
+
+// EvictedMessages returns the number of messages, which were removed
+// from the temporary logs because of the limits.
+func (w *GelfWriter) EvictedMessages() uint64 {
+	if w.spool == nil {
+		return 0
+	}
+	return w.spool.evictedMessages()
+}
+
+// writeTemporaryLog saves the messages remaining in the buffer to the
+// temporary log path, so they can be sent later on. It returns false,
+// if the messages could not be saved. Messages refused because of the
+// limits are already counted as evicted.
+func (w *GelfWriter) writeTemporaryLog(buffer *logBuffer) bool {
+	if w.spool == nil {
+		return false
+	}
+	if err := w.spool.write(buffer); err != nil {
+		w.handleError(ErrorKindSpool, err)
+		return err == ErrorSpoolFull
+	}
+	return true
+}
+
+// sendTemporaryLogs sends previously saved logs, if any
 func (w *GelfWriter) sendTemporaryLogs() {
-	// TODO: send previously saved logs, if any
+	if w.spool == nil {
+		return
+	}
+	send := func(buffer *logBuffer) error {
+		err := w.transport.SendBuffer(buffer)
+		var sendErr *SendError
+		if !errors.As(err, &sendErr) {
+			return err
+		}
+		// keep the messages which failed in the temporary log
+		for _, d := range w.handleSendError(sendErr) {
+			buffer.Add(d)
+		}
+		if buffer.Size() == 0 {
+			return nil
+		}
+		return err
+	}
+	if err := w.spool.replay(send); err != nil {
+		w.handleError(ErrorKindSpool, err)
+	}
 }
 
 func parseCaller(caller string) (file string, line int, err error) {
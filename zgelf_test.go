@@ -0,0 +1,822 @@
+package zgelf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTransport records all messages sent, it fails while `fail` is set.
+type testTransport struct {
+	mu       sync.Mutex
+	fail     bool
+	messages []map[string]interface{}
+	// if set, SendBuffer signals `entered` and waits for `block` to close
+	block   chan struct{}
+	entered chan struct{}
+}
+
+func (t *testTransport) Mode() TransportMode {
+	return TransportMode("test")
+}
+
+func (t *testTransport) BufferSize() int {
+	return -1
+}
+
+func (t *testTransport) BufferTime() time.Duration {
+	return 0
+}
+
+func (t *testTransport) SendBuffer(buffer *logBuffer) error {
+	if t.block != nil {
+		select {
+		case t.entered <- struct{}{}:
+		default:
+		}
+		<-t.block
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.fail {
+		return errors.New("transport failed")
+	}
+	for buffer.Size() > 0 {
+		d, _ := buffer.Pull()
+		var m map[string]interface{}
+		if err := json.Unmarshal(d, &m); err != nil {
+			return err
+		}
+		t.messages = append(t.messages, m)
+	}
+	return nil
+}
+
+func (t *testTransport) setFail(fail bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fail = fail
+}
+
+func (t *testTransport) sent() []map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]map[string]interface{}{}, t.messages...)
+}
+
+func newTestWriter(t *testing.T, tr transport, opts ...Option) *GelfWriter {
+	t.Helper()
+	w, err := New(tr, append([]Option{WithHost("test")}, opts...)...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return w
+}
+
+func TestGelfWriter_TemporaryLogs(t *testing.T) {
+	dir := t.TempDir()
+	tr := &testTransport{fail: true}
+
+	w := newTestWriter(t, tr, WithSpoolDir(dir))
+	_, _ = w.Write([]byte(`{"level":"info","message":"first"}`))
+	w.Flush(true)
+	w.Close()
+
+	if len(tr.sent()) != 0 {
+		t.Fatalf("messages sent by failing transport: %v", tr.sent())
+	}
+	s, _ := newSpool(dir)
+	if s.pendingFiles() == 0 {
+		t.Fatal("no temporary log written")
+	}
+
+	// a new writer sends the temporary logs of the previous one
+	tr.setFail(false)
+	w = newTestWriter(t, tr, WithSpoolDir(dir))
+	_, _ = w.Write([]byte(`{"level":"info","message":"second"}`))
+	w.Close()
+
+	sent := tr.sent()
+	if len(sent) != 2 {
+		t.Fatalf("want 2 messages, got: %v", sent)
+	}
+	got := map[interface{}]bool{}
+	for _, m := range sent {
+		got[m[ShortMessageFieldName]] = true
+	}
+	if !got["first"] || !got["second"] {
+		t.Errorf("unexpected messages sent: %v", sent)
+	}
+	if files, _ := s.files(); len(files) != 0 {
+		t.Errorf("temporary logs left: %v", files)
+	}
+}
+
+func TestGelfWriter_Overflow(t *testing.T) {
+	s, err := newSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a writer without worker, so the queue cannot drain
+	w := &GelfWriter{
+		transport: &testTransport{},
+		opts:      options{levelMapping: defaultLevelMapping(), keyNormalizer: SnakeCaseKeys},
+		spool:     s,
+		buffer:    NewLogBuffer(),
+		spill:     NewLogBuffer(),
+		spills:    make(chan map[string]interface{}, 1),
+		queue:     newEventQueue(1),
+	}
+
+	if err := w.SetOverflowPolicy(OverflowDropNewest); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		_, _ = w.Write([]byte(`{"level":"info","message":"dropped"}`))
+	}
+	if w.DroppedMessages() != 2 {
+		t.Errorf("DroppedMessages() = %d, want 2", w.DroppedMessages())
+	}
+
+	if err := w.SetOverflowPolicy(OverflowSpill); err != nil {
+		t.Fatal(err)
+	}
+	// the second event does not fit into the spiller's queue,
+	// it is dropped instead of blocking the caller
+	_, _ = w.Write([]byte(`{"level":"info","message":"spilled"}`))
+	_, _ = w.Write([]byte(`{"level":"info","message":"dropped"}`))
+	if w.DroppedMessages() != 3 {
+		t.Errorf("DroppedMessages() = %d, want 3", w.DroppedMessages())
+	}
+	w.collectSpills()
+	w.flushSpill()
+
+	var got []string
+	_ = w.spool.replay(func(buffer *logBuffer) error {
+		got = append(got, messagesOf(buffer)...)
+		buffer.Clear()
+		return nil
+	})
+	if len(got) != 1 {
+		t.Errorf("spilled messages = %v, want 1", got)
+	}
+
+	if err := w.SetOverflowPolicy("unknown"); err == nil {
+		t.Errorf("SetOverflowPolicy() want error for unknown policy")
+	}
+	if err := w.SetQueueSize(0); err == nil {
+		t.Errorf("SetQueueSize() want error for size 0")
+	}
+}
+
+func TestGelfWriter_PreserveOrder(t *testing.T) {
+	tr := &testTransport{}
+	w := newTestWriter(t, tr)
+	if err := w.SetWorkers(8); err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 1000
+	for i := 0; i < count; i++ {
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"level":"info","message":"%d"}`, i)))
+	}
+	// fewer workers, the remaining ones still process all events
+	if err := w.SetWorkers(2); err != nil {
+		t.Fatal(err)
+	}
+	for i := count; i < count*2; i++ {
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"level":"info","message":"%d"}`, i)))
+	}
+	w.Close()
+
+	sent := tr.sent()
+	if len(sent) != count*2 {
+		t.Fatalf("want %d messages, got: %d", count*2, len(sent))
+	}
+	for i, m := range sent {
+		if m[ShortMessageFieldName] != strconv.Itoa(i) {
+			t.Fatalf("message %d out of order: %v", i, m[ShortMessageFieldName])
+		}
+	}
+
+	if err := w.SetWorkers(0); err == nil {
+		t.Errorf("SetWorkers() want error for 0 workers")
+	}
+}
+
+func Test_sequencer_deliver(t *testing.T) {
+	var got []string
+	add := func(d []byte) {
+		got = append(got, string(d))
+	}
+
+	s := newSequencer()
+	s.deliver(1, []byte("1"), add)
+	s.deliver(2, nil, add)
+	s.deliver(3, []byte("3"), add)
+	if len(got) != 0 {
+		t.Fatalf("messages passed before their predecessor: %v", got)
+	}
+	s.deliver(0, []byte("0"), add)
+	if strings.Join(got, ",") != "0,1,3" {
+		t.Errorf("deliver() order = %v, want 0,1,3", got)
+	}
+
+	got = nil
+	s.setPreserve(false)
+	s.deliver(5, []byte("5"), add)
+	s.deliver(4, []byte("4"), add)
+	if strings.Join(got, ",") != "5,4" {
+		t.Errorf("deliver() without order = %v, want 5,4", got)
+	}
+	if len(s.pending) != 0 || s.next != 6 {
+		t.Errorf("sequence not tracked, pending: %v, next: %d", s.pending, s.next)
+	}
+}
+
+func TestGelfWriter_Shutdown(t *testing.T) {
+	tests := []struct {
+		name        string
+		spool       bool
+		wantSpooled int
+		wantDropped int
+	}{
+		{"spool on timeout", true, 10, 0},
+		{"drop on timeout", false, 0, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := ""
+			if tt.spool {
+				dir = t.TempDir()
+			}
+			tr := &testTransport{block: make(chan struct{}), entered: make(chan struct{}, 1)}
+			defer close(tr.block)
+			w := newTestWriter(t, tr, WithSpoolDir(dir))
+
+			// the first message blocks the transport, the others stay in the buffer,
+			// all of them are saved, since the transport does not finish
+			_, _ = w.Write([]byte(`{"level":"info","message":"0"}`))
+			select {
+			case <-tr.entered:
+			case <-time.After(time.Second):
+				t.Fatal("transport not called")
+			}
+			for i := 1; i < 10; i++ {
+				_, _ = w.Write([]byte(fmt.Sprintf(`{"level":"info","message":"%d"}`, i)))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+			defer cancel()
+			err := w.Shutdown(ctx)
+
+			var se *ShutdownError
+			if !errors.As(err, &se) {
+				t.Fatalf("Shutdown() error = %v, want *ShutdownError", err)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if se.Spooled != tt.wantSpooled || se.Dropped != tt.wantDropped {
+				t.Errorf("Shutdown() spooled = %d, dropped = %d, want %d, %d",
+					se.Spooled, se.Dropped, tt.wantSpooled, tt.wantDropped)
+			}
+			if w.DroppedMessages() != uint64(tt.wantDropped) {
+				t.Errorf("DroppedMessages() = %d, want %d", w.DroppedMessages(), tt.wantDropped)
+			}
+
+			// closing the writer again returns the result of the shutdown
+			if err2 := w.Shutdown(context.Background()); err2 != err {
+				t.Errorf("second Shutdown() error = %v, want %v", err2, err)
+			}
+			w.Close()
+		})
+	}
+}
+
+func TestGelfWriter_ShutdownBusyWorkers(t *testing.T) {
+	dir := t.TempDir()
+	entered := make(chan struct{}, 10)
+	release := make(chan struct{})
+	slow := func() map[string]interface{} {
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	}
+	w := newTestWriter(t, &testTransport{}, WithSpoolDir(dir), WithWorkers(3),
+		WithDynamicFields(slow))
+
+	// three events are processed by the workers, two are left in the queue
+	for i := 0; i < 5; i++ {
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"level":"info","message":"%d"}`, i)))
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-entered:
+		case <-time.After(time.Second):
+			t.Fatal("workers not busy")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		time.Sleep(time.Millisecond * 50)
+		close(release)
+	}()
+	err := w.Shutdown(ctx)
+
+	var se *ShutdownError
+	if !errors.As(err, &se) {
+		t.Fatalf("Shutdown() error = %v, want *ShutdownError", err)
+	}
+	if se.Spooled != 5 || se.Dropped != 0 {
+		t.Errorf("Shutdown() spooled = %d, dropped = %d, want 5, 0", se.Spooled, se.Dropped)
+	}
+	if w.buffer.Size() != 0 {
+		t.Errorf("messages left in buffer: %d", len(w.buffer.buffers))
+	}
+
+	var got []string
+	s, _ := newSpool(dir)
+	_ = s.replay(func(buffer *logBuffer) error {
+		for _, d := range messagesOf(buffer) {
+			var m map[string]interface{}
+			_ = json.Unmarshal([]byte(d), &m)
+			got = append(got, fmt.Sprint(m[ShortMessageFieldName]))
+		}
+		buffer.Clear()
+		return nil
+	})
+	if strings.Join(got, ",") != "0,1,2,3,4" {
+		t.Errorf("spooled messages = %v, want 0,1,2,3,4", got)
+	}
+}
+
+func TestGelfWriter_ShutdownBackpressure(t *testing.T) {
+	tr := &testTransport{block: make(chan struct{}), entered: make(chan struct{}, 1)}
+	defer close(tr.block)
+	w := newTestWriter(t, tr, WithSpoolDir(t.TempDir()), WithWorkers(1))
+
+	_, _ = w.Write([]byte(`{"level":"info","message":"0"}`))
+	select {
+	case <-tr.entered:
+	case <-time.After(time.Second):
+		t.Fatal("transport not called")
+	}
+	// the buffer exceeds the max size, so the worker waits for the transport
+	large := strings.Repeat("x", maxBufferedSize/4)
+	for i := 1; i < 6; i++ {
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"level":"info","message":"%d %s"}`, i, large)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		var se *ShutdownError
+		if !errors.As(err, &se) {
+			t.Fatalf("Shutdown() error = %v, want *ShutdownError", err)
+		}
+		if se.Spooled != 6 || se.Dropped != 0 {
+			t.Errorf("Shutdown() spooled = %d, dropped = %d, want 6, 0", se.Spooled, se.Dropped)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Shutdown() waits for the transport")
+	}
+}
+
+func TestGelfWriter_FlushContext(t *testing.T) {
+	tr := &testTransport{}
+	w := newTestWriter(t, tr)
+	defer w.Close()
+
+	_, _ = w.Write([]byte(`{"level":"info","message":"one"}`))
+	if err := w.FlushContext(context.Background()); err != nil {
+		t.Fatalf("FlushContext() error = %v", err)
+	}
+	if len(tr.sent()) != 1 {
+		t.Errorf("want 1 message sent, got: %v", tr.sent())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tr.setFail(true)
+	_, _ = w.Write([]byte(`{"level":"info","message":"two"}`))
+	if err := w.FlushContext(ctx); err != context.Canceled {
+		t.Errorf("FlushContext() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestGelfWriter_ErrorHandler(t *testing.T) {
+	tr := &testTransport{fail: true}
+	w := newTestWriter(t, tr, WithDeadLetterHandler(func(event []byte, err error) {}))
+	defer w.Close()
+
+	var mu sync.Mutex
+	kinds := map[ErrorKind]int{}
+	w.SetErrorHandler(func(err *Error) {
+		mu.Lock()
+		defer mu.Unlock()
+		kinds[err.Kind]++
+	})
+
+	if _, err := w.Write([]byte(`{"level":`)); err == nil {
+		t.Errorf("Write() want error for invalid JSON")
+	}
+	_, _ = w.Write([]byte(`{"level":"info","message":"one"}`))
+	w.Flush(true)
+
+	mu.Lock()
+	if kinds[ErrorKindDecode] != 1 || kinds[ErrorKindSend] != 1 {
+		t.Errorf("unexpected errors reported: %v", kinds)
+	}
+	mu.Unlock()
+
+	// the default handler discards the errors
+	w.SetErrorHandler(nil)
+	_, _ = w.Write([]byte(`{"level":`))
+}
+
+func TestGelfWriter_Timestamp(t *testing.T) {
+	tr := &testTransport{}
+	w := newTestWriter(t, tr)
+	before := float64(time.Now().Unix())
+	_, _ = w.Write([]byte(`{"level":"info","time":"2022-10-10T14:17:58.123456Z","message":"rfc3339"}`))
+	_, _ = w.Write([]byte(`{"level":"info","message":"missing"}`))
+	w.Close()
+
+	sent := tr.sent()
+	if len(sent) != 2 {
+		t.Fatalf("want 2 messages, got: %v", sent)
+	}
+	for _, m := range sent {
+		ts, _ := m[TimestampFieldName].(float64)
+		switch m[ShortMessageFieldName] {
+		case "rfc3339":
+			if math.Abs(ts-1665411478.123456) > 1e-6 {
+				t.Errorf("timestamp = %f, want 1665411478.123456", ts)
+			}
+		case "missing":
+			if ts < before || ts > float64(time.Now().Unix()+1) {
+				t.Errorf("timestamp = %f, want receive time", ts)
+			}
+		}
+	}
+}
+
+func TestGelfWriter_DeadLetter(t *testing.T) {
+	events := []string{
+		`{"level":"info","message":"valid"}`,
+		`{"level":2,"message":"numeric level"}`,
+		`{"level":true,"message":"invalid level"}`,
+		`{"level":"info","time":"yesterday","message":"invalid time"}`,
+		`{"level":"info","caller":42,"message":"invalid caller"}`,
+		`{"level":"info","message":{"text":"invalid message"}}`,
+		`null`,
+		`{"level":`,
+	}
+
+	t.Run("handler", func(t *testing.T) {
+		var mu sync.Mutex
+		var dead []string
+		tr := &testTransport{}
+		w := newTestWriter(t, tr, WithDeadLetterHandler(func(event []byte, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				t.Errorf("dead letter without error: %s", event)
+			}
+			dead = append(dead, string(event))
+		}))
+		for _, e := range events {
+			_, _ = w.Write([]byte(e))
+		}
+		w.Close()
+
+		sent := tr.sent()
+		if len(sent) != 2 {
+			t.Errorf("want 2 messages, got: %v", sent)
+		}
+		for _, m := range sent {
+			if m[ShortMessageFieldName] == "numeric level" && m[LevelFieldName] != float64(4) {
+				t.Errorf("unexpected level: %v", m)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(dead) != 6 {
+			t.Errorf("want 6 dead letters, got: %v", dead)
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		tr := &testTransport{}
+		w := newTestWriter(t, tr)
+		for _, e := range events {
+			_, _ = w.Write([]byte(e))
+		}
+		w.Close()
+
+		sent := tr.sent()
+		if len(sent) != len(events) {
+			t.Fatalf("want %d messages, got: %v", len(events), sent)
+		}
+		var problems int
+		for _, m := range sent {
+			if m[ProcessingErrorFieldName] == nil {
+				continue
+			}
+			problems++
+			msg, _ := m[ShortMessageFieldName].(string)
+			if !strings.HasPrefix(msg, "cannot process log event: ") || m[FullMessageFieldName] == "" ||
+				m[LevelFieldName] != float64(3) || m[HostFieldName] != "test" {
+				t.Errorf("unexpected message: %v", m)
+			}
+		}
+		if problems != 6 {
+			t.Errorf("want 6 problem messages, got %d", problems)
+		}
+	})
+}
+
+func TestGelfWriter_DeadLetterStaticFields(t *testing.T) {
+	tr := &testTransport{}
+	w := newTestWriter(t, tr, WithStaticFields(map[string]interface{}{
+		"build": map[string]interface{}{"git sha": "abc"},
+	}))
+	_, _ = w.Write([]byte(`{"level":true,"message":"malformed"}`))
+	w.Close()
+
+	sent := tr.sent()
+	if len(sent) != 1 {
+		t.Fatalf("want 1 message, got: %v", sent)
+	}
+	if sent[0]["_build.gitsha"] != "abc" {
+		t.Errorf("static fields not flattened: %v", sent[0])
+	}
+}
+
+var validKey = regexp.MustCompile(`^[\w.\-]*$`)
+
+func TestGelfWriter_Flatten(t *testing.T) {
+	event := `{"level":"info","message":"nested",` +
+		`"http":{"request":{"method":"GET","header":{"accept":{"type":"json"}}}},` +
+		`"tags":["a","b"],"empty":{},"error":{"code":42,"bad key":1},"stack":[{"func":"main"}]}`
+
+	tests := []struct {
+		name string
+		opts []Option
+		want map[string]interface{}
+	}{
+		{"default", nil, map[string]interface{}{
+			"_http.request.method":             "GET",
+			"_http.request.header.accept.type": "json",
+			"_tags.0":                          "a",
+			"_tags.1":                          "b",
+			"_empty":                           "{}",
+			"_err.code":                        float64(42),
+			"_err.badkey":                      float64(1),
+			"_err_stack":                       `[{"func":"main"}]`,
+		}},
+		{"max depth", []Option{WithMaxDepth(2)}, map[string]interface{}{
+			"_http.request.method": "GET",
+			"_http.request.header": `{"accept":{"type":"json"}}`,
+			"_tags.0":              "a",
+			"_tags.1":              "b",
+		}},
+		{"no flattening", []Option{WithMaxDepth(0)}, map[string]interface{}{
+			"_http": `{"request":{"header":{"accept":{"type":"json"}},"method":"GET"}}`,
+			"_tags": `["a","b"]`,
+			"_err":  `{"bad key":1,"code":42}`,
+		}},
+		{"arrays as json", []Option{WithArraysAsJSON(true)}, map[string]interface{}{
+			"_http.request.method": "GET",
+			"_tags":                `["a","b"]`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &testTransport{}
+			w := newTestWriter(t, tr, tt.opts...)
+			_, _ = w.Write([]byte(event))
+			w.Close()
+
+			sent := tr.sent()
+			if len(sent) != 1 {
+				t.Fatalf("want 1 message, got: %v", sent)
+			}
+			for k, v := range tt.want {
+				if sent[0][k] != v {
+					t.Errorf("field %s = %v, want %v", k, sent[0][k], v)
+				}
+			}
+			for k, v := range sent[0] {
+				switch v.(type) {
+				case map[string]interface{}, []interface{}:
+					t.Errorf("field %s is not flattened: %v", k, v)
+				}
+				if !validKey.MatchString(k) {
+					t.Errorf("invalid field name: %q", k)
+				}
+			}
+		})
+	}
+}
+
+func TestGelfWriter_KeyNormalizer(t *testing.T) {
+	event := `{"level":"info","message":"keys","userId":1,"user_id":2,"_user_id":3,"file":"a"}`
+	tests := []struct {
+		name string
+		opts []Option
+		want map[string]interface{}
+	}{
+		{"snake case", nil, map[string]interface{}{
+			"_user_id":   float64(3),
+			"_user_id_1": float64(1),
+			"_user_id_2": float64(2),
+			"_file":      "a",
+		}},
+		{"keep", []Option{WithKeyNormalizer(KeepKeys)}, map[string]interface{}{
+			"_userId":    float64(1),
+			"_user_id":   float64(3),
+			"_user_id_1": float64(2),
+		}},
+		{"mapping", []Option{WithFieldMapping(map[string]string{"userId": "userID"})}, map[string]interface{}{
+			"_userID":    float64(1),
+			"_user_id":   float64(3),
+			"_user_id_1": float64(2),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				tr := &testTransport{}
+				w := newTestWriter(t, tr, tt.opts...)
+				_, _ = w.Write([]byte(event))
+				w.Close()
+
+				sent := tr.sent()
+				if len(sent) != 1 {
+					t.Fatalf("want 1 message, got: %v", sent)
+				}
+				for k, v := range tt.want {
+					if sent[0][k] != v {
+						t.Errorf("field %s = %v, want %v", k, sent[0][k], v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGelfWriter_FullMessage(t *testing.T) {
+	event := `{"level":"error","message":"request failed\nstatus: 500","stack":[{"func":"main.run","source":"main.go","line":"12"}]}`
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantShort string
+		wantFull  interface{}
+		wantStack interface{}
+	}{
+		{"default", nil, "request failed", "request failed\nstatus: 500",
+			`[{"func":"main.run","line":"12","source":"main.go"}]`},
+		{"stack", []Option{WithStackInFullMessage(true)}, "request failed",
+			"request failed\nstatus: 500\n\nmain.run\n\tmain.go:12", nil},
+		{"length", []Option{WithShortMessageLength(7)}, "request", "request failed\nstatus: 500",
+			`[{"func":"main.run","line":"12","source":"main.go"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &testTransport{}
+			w := newTestWriter(t, tr, tt.opts...)
+			_, _ = w.Write([]byte(event))
+			_, _ = w.Write([]byte(`{"level":"info","message":"short"}`))
+			w.Close()
+
+			sent := tr.sent()
+			if len(sent) != 2 {
+				t.Fatalf("want 2 messages, got: %v", sent)
+			}
+			for _, m := range sent {
+				if m[ShortMessageFieldName] == "short" {
+					if _, ok := m[FullMessageFieldName]; ok {
+						t.Errorf("unexpected full message: %v", m)
+					}
+					continue
+				}
+				if m[ShortMessageFieldName] != tt.wantShort || m[FullMessageFieldName] != tt.wantFull ||
+					m[ErrorStackFieldName] != tt.wantStack {
+					t.Errorf("unexpected message: %v", m)
+				}
+			}
+		})
+	}
+}
+
+// partialTransport fails to send the messages containing "fail"
+// and rejects the messages containing "reject".
+type partialTransport struct {
+	testTransport
+}
+
+func (t *partialTransport) SendBuffer(buffer *logBuffer) error {
+	sendErr := &SendError{}
+	ok := NewLogBuffer()
+	for buffer.Size() > 0 {
+		d, _ := buffer.Pull()
+		switch {
+		case bytes.Contains(d, []byte("fail")):
+			sendErr.add(d, errors.New("failed"))
+		case bytes.Contains(d, []byte("reject")):
+			sendErr.add(d, ErrorMessageTooLarge)
+		default:
+			ok.Add(d)
+		}
+	}
+	_ = t.testTransport.SendBuffer(ok)
+	if len(sendErr.Errors) > 0 {
+		return sendErr
+	}
+	return nil
+}
+
+func TestGelfWriter_SendError(t *testing.T) {
+	dir := t.TempDir()
+	tr := &partialTransport{}
+	w := newTestWriter(t, tr, WithSpoolDir(dir), WithWorkers(1))
+	for _, m := range []string{"one", "fail", "reject", "two"} {
+		_, _ = w.Write([]byte(`{"level":"info","message":"` + m + `"}`))
+	}
+	w.Close()
+
+	if sent := tr.sent(); len(sent) != 2 {
+		t.Errorf("want 2 messages sent, got: %v", sent)
+	}
+	if w.DroppedMessages() != 1 {
+		t.Errorf("DroppedMessages() = %d, want 1", w.DroppedMessages())
+	}
+
+	s, _ := newSpool(dir)
+	var spooled []string
+	_ = s.replay(func(buffer *logBuffer) error {
+		spooled = append(spooled, messagesOf(buffer)...)
+		buffer.Clear()
+		return nil
+	})
+	if len(spooled) != 1 || !strings.Contains(spooled[0], "fail") {
+		t.Errorf("unexpected messages spooled: %v", spooled)
+	}
+}
+
+func TestGelfWriter_SendErrorReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewLogBuffer()
+	for _, m := range []string{"one", "fail", "reject"} {
+		b.Add([]byte(`{"short_message":"` + m + `"}`))
+	}
+	if err := s.write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := &partialTransport{}
+	w := newTestWriter(t, tr, WithSpoolDir(dir))
+	w.Close()
+
+	if sent := tr.sent(); len(sent) != 1 || sent[0][ShortMessageFieldName] != "one" {
+		t.Errorf("unexpected messages sent: %v", sent)
+	}
+	var spooled []string
+	_ = s.replay(func(buffer *logBuffer) error {
+		spooled = append(spooled, messagesOf(buffer)...)
+		buffer.Clear()
+		return nil
+	})
+	if len(spooled) != 1 || !strings.Contains(spooled[0], "fail") {
+		t.Errorf("unexpected messages spooled: %v", spooled)
+	}
+}